@@ -1 +1,17 @@
 package main
+
+// programArgs are the arguments given to the Ava program, which follow `--`
+// on the command line, e.g. `ava run file.ava -- a b`.
+var programArgs = make([]string, 0)
+
+// splitProgramArgs separates the arguments of the ava command from the ones
+// passed on to the program.
+func splitProgramArgs(args []string) ([]string, []string) {
+	for k, arg := range args {
+		if arg == "--" {
+			return args[:k], args[k+1:]
+		}
+	}
+
+	return args, make([]string, 0)
+}
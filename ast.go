@@ -3,10 +3,52 @@ package main
 import (
 	"fmt"
 	"io"
+	"log"
 	"strings"
 )
 
+// SyntaxError is panicked by the lexer and the parser on invalid source.
+type SyntaxError struct {
+	Message string
+}
+
+func (e SyntaxError) Error() string {
+	return e.Message
+}
+
+func syntaxError(format string, args ...any) {
+	panic(SyntaxError{
+		Message: strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"),
+	})
+}
+
+// catchSyntaxError stores a SyntaxError panicked by the lexer or the parser
+// in err, other panics keep going.
+func catchSyntaxError(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	syntaxErr, ok := r.(SyntaxError)
+	if !ok {
+		panic(r)
+	}
+	*err = syntaxErr
+}
+
 func CreateAst(source io.Reader) ProgStmt {
+	tree, err := parseProgram(source)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return tree
+}
+
+func parseProgram(source io.Reader) (tree ProgStmt, err error) {
+	defer catchSyntaxError(&err)
+
 	lexer := NewLexer(source)
 	tokens := lexer.ReadAllTokens()
 
@@ -17,7 +59,7 @@ func CreateAst(source io.Reader) ProgStmt {
 	}
 
 	parser := NewParser(tokens)
-	return parser.Parse()
+	return parser.Parse(), nil
 }
 
 // Base
@@ -47,15 +89,48 @@ type GlblStmt interface {
 	glblStmt()
 }
 
+// Array literal
+
+type ArrayLit struct {
+	Elems []Expr
+	Pos   Pos
+}
+
+func (a ArrayLit) Accept(interp Visitor) AvaVal {
+	return interp.VisitArrayLit(a)
+}
+
+func (a ArrayLit) String() string {
+	elems := Map(a.Elems, func(e Expr) string {
+		return e.String()
+	})
+
+	return fmt.Sprintf("ArrayLit(%s)", strings.Join(elems, ", "))
+}
+
+func (a ArrayLit) exprNode() {}
+
 // Assign statement
 
 type AssignStmt struct {
-	Variable string
-	Value    Expr
+	// Target is a variable, a field, an element or a dereference like `*r`.
+	Target Expr
+	Value  Expr
+	// Op is the operator of a compound assignment like `+=`, or "" for `=`.
+	Op string
+	// IsPostfix is set for `n++` and `n--`, which are `n += 1` and `n -= 1`.
+	IsPostfix bool
+	Pos       Pos
 }
 
 func (a AssignStmt) String() string {
-	return fmt.Sprintf("AssignStmt(%s, %s)", a.Variable, a.Value.String())
+	if a.IsPostfix {
+		return fmt.Sprintf("AssignStmt(%s, %s%s)", a.Target.String(), a.Op, a.Op)
+	} else if a.Op != "" {
+		return fmt.Sprintf("AssignStmt(%s, %s, %s)", a.Target.String(), a.Op, a.Value.String())
+	}
+
+	return fmt.Sprintf("AssignStmt(%s, %s)", a.Target.String(), a.Value.String())
 }
 
 func (a AssignStmt) Accept(interp Visitor) AvaVal {
@@ -71,6 +146,8 @@ func (a AssignStmt) exprNode() {}
 type Block struct {
 	Stmts          []Stmt
 	ImplicitReturn *Expr
+	// End is the position of the closing brace.
+	End Pos
 }
 
 func (b Block) String() string {
@@ -106,13 +183,59 @@ func (b BoolLit) String() string {
 
 func (b BoolLit) exprNode() {}
 
+// Call expression
+
+// CallExpr calls the function value an expression evaluates to, e.g.
+// `adders[0](1)` or `make_adder(1)(2)`.
+type CallExpr struct {
+	Callee Expr
+	Args   []Expr
+	Pos    Pos
+}
+
+func (c CallExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitCallExpr(c)
+}
+
+func (c CallExpr) String() string {
+	args := Map(c.Args, func(arg Expr) string {
+		return ", " + arg.String()
+	})
+
+	return fmt.Sprintf("CallExpr(%s%s)", c.Callee.String(), strings.Join(args, ""))
+}
+
+func (c CallExpr) exprNode() {}
+
+// Coalesce expression
+
+// CoalesceExpr is `left ?? right`, which is right when left is nil.
+type CoalesceExpr struct {
+	Left  Expr
+	Right Expr
+}
+
+func (c CoalesceExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitCoalesceExpr(c)
+}
+
+func (c CoalesceExpr) String() string {
+	return fmt.Sprintf("CoalesceExpr(%s, %s)", c.Left.String(), c.Right.String())
+}
+
+func (c CoalesceExpr) exprNode() {}
+
 // Const declaration statement
 
 type ConstDecl struct {
 	Name     string
-	Type     string
+	Type     TypeSpec
 	Init     Expr
 	IsGlobal bool
+	Pos      Pos
+	// Value is the result of Init, which the checker evaluates at compile
+	// time. It is shared by all copies of the declaration.
+	Value *AvaVal
 }
 
 func (c ConstDecl) Accept(interp Visitor) AvaVal {
@@ -120,7 +243,7 @@ func (c ConstDecl) Accept(interp Visitor) AvaVal {
 }
 
 func (c ConstDecl) String() string {
-	typ := c.Type
+	typ := c.Type.String()
 	if len(typ) == 0 {
 		typ = "?"
 	}
@@ -135,10 +258,91 @@ func (c ConstDecl) glblStmt() {}
 
 func (c ConstDecl) declNode() {}
 
+// Dereference expression
+
+type DerefExpr struct {
+	Expr Expr
+	Pos  Pos
+}
+
+func (d DerefExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitDerefExpr(d)
+}
+
+func (d DerefExpr) String() string {
+	return fmt.Sprintf("DerefExpr(%s)", d.Expr.String())
+}
+
+func (d DerefExpr) exprNode() {}
+
+// Defer statement
+
+// DeferStmt is `defer expr;`, which runs expr when the function returns.
+type DeferStmt struct {
+	Expr Expr
+	Pos  Pos
+}
+
+func (d DeferStmt) Accept(interp Visitor) AvaVal {
+	return interp.VisitDeferStmt(d)
+}
+
+func (d DeferStmt) String() string {
+	return fmt.Sprintf("DeferStmt(%s)", d.Expr.String())
+}
+
+func (d DeferStmt) stmtNode() {}
+
+// Enum declaration statement
+
+type EnumDecl struct {
+	Name       string
+	TypeParams []TypeParam
+	Variants   []EnumVariant
+	Pos        Pos
+	// End is the position of the closing brace.
+	End Pos
+}
+
+type EnumVariant struct {
+	Name   string
+	Fields []TypeSpec
+	Pos    Pos
+}
+
+func (e EnumDecl) String() string {
+	variants := Map(e.Variants, func(v EnumVariant) string {
+		return v.String()
+	})
+
+	return fmt.Sprintf("EnumDecl(%s%s, %s)", e.Name, typeParamsString(e.TypeParams), strings.Join(variants, ", "))
+}
+
+func (e EnumDecl) Accept(interp Visitor) AvaVal {
+	return interp.VisitEnumDecl(e)
+}
+
+func (e EnumDecl) stmtNode() {}
+
+func (e EnumDecl) glblStmt() {}
+
+func (v EnumVariant) String() string {
+	if len(v.Fields) == 0 {
+		return v.Name
+	}
+
+	fields := Map(v.Fields, func(t TypeSpec) string {
+		return t.String()
+	})
+
+	return fmt.Sprintf("%s(%s)", v.Name, strings.Join(fields, ", "))
+}
+
 // Expression statement
 
 type ExprStmt struct {
 	Expr Expr
+	Pos  Pos
 }
 
 func (e ExprStmt) String() string {
@@ -151,10 +355,38 @@ func (e ExprStmt) Accept(interp Visitor) AvaVal {
 
 func (e ExprStmt) stmtNode() {}
 
+// Field expression
+
+type FieldExpr struct {
+	Expr Expr
+	// Field is a field name or a tuple index like `0`.
+	Field string
+	// Optional is set for `x?.field`, which is nil when x is nil.
+	Optional bool
+	Pos      Pos
+}
+
+func (f FieldExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitFieldExpr(f)
+}
+
+func (f FieldExpr) String() string {
+	if f.Optional {
+		return fmt.Sprintf("FieldExpr(%s, ?%s)", f.Expr.String(), f.Field)
+	}
+
+	return fmt.Sprintf("FieldExpr(%s, %s)", f.Expr.String(), f.Field)
+}
+
+func (f FieldExpr) exprNode() {}
+
 // Float literal
 
 type FloatLit struct {
 	Value float64
+	Pos   Pos
+	// Type is the type given by a suffix, e.g. `3.0f32`, or "" for f64.
+	Type string
 }
 
 func (f FloatLit) Accept(interp Visitor) AvaVal {
@@ -167,6 +399,32 @@ func (f FloatLit) String() string {
 
 func (f FloatLit) exprNode() {}
 
+// For statement
+
+type ForStmt struct {
+	// Key is the optional index (or map key) variable in `for i, x in ...`.
+	Key      string
+	Value    string
+	Iterable Expr
+	Body     Block
+	Pos      Pos
+}
+
+func (f ForStmt) String() string {
+	vars := f.Value
+	if len(f.Key) > 0 {
+		vars = f.Key + ", " + vars
+	}
+
+	return fmt.Sprintf("ForStmt(%s, %s, %s)", vars, f.Iterable.String(), f.Body.String())
+}
+
+func (f ForStmt) Accept(interp Visitor) AvaVal {
+	return interp.VisitForStmt(f)
+}
+
+func (f ForStmt) stmtNode() {}
+
 // Function call expression
 
 type FuncCall struct {
@@ -174,6 +432,7 @@ type FuncCall struct {
 	IsArithmetic bool
 	IsComparison bool
 	Args         []Expr
+	Pos          Pos
 }
 
 func (f FuncCall) Accept(interp Visitor) AvaVal {
@@ -199,9 +458,13 @@ func (f FuncCall) exprNode() {}
 
 type FuncDecl struct {
 	Name       string
-	ReturnType string
+	TypeParams []TypeParam
+	ReturnType TypeSpec
 	Params     []FuncParam
 	Body       Block
+	// IsConst marks a `const fun`, which can be called in const initializers.
+	IsConst bool
+	Pos     Pos
 }
 
 func (f FuncDecl) Accept(interp Visitor) AvaVal {
@@ -210,11 +473,23 @@ func (f FuncDecl) Accept(interp Visitor) AvaVal {
 
 type FuncParam struct {
 	Name string
-	Type string
+	Type TypeSpec
+	// Default is used when a call leaves out the argument, it is nil for
+	// required parameters.
+	Default Expr
+	// IsVariadic marks `xs: ...i32`, which takes the remaining arguments of a
+	// call. Type is the slice the function receives them in, `[]i32`.
+	IsVariadic bool
+}
+
+type TypeParam struct {
+	Name string
+	// Bounds are the traits the type argument must implement, e.g. `T: Printable`.
+	Bounds []string
 }
 
 func (f FuncDecl) String() string {
-	retType := f.ReturnType
+	retType := f.ReturnType.String()
 	if len(retType) == 0 {
 		retType = "?"
 	}
@@ -229,7 +504,7 @@ func (f FuncDecl) String() string {
 
 	bodyStr := f.Body.String()
 
-	return fmt.Sprintf("FuncDecl(\n\t%s,\n\t%s,\n%s\t%s\n)", f.Name, retType, paramsStr, bodyStr)
+	return fmt.Sprintf("FuncDecl(\n\t%s%s,\n\t%s,\n%s\t%s\n)", f.Name, typeParamsString(f.TypeParams), retType, paramsStr, bodyStr)
 }
 
 func (f FuncDecl) stmtNode() {}
@@ -237,9 +512,97 @@ func (f FuncDecl) stmtNode() {}
 func (f FuncDecl) glblStmt() {}
 
 func (f FuncParam) String() string {
-	return fmt.Sprintf("FuncParam(%s, %s)", f.Name, f.Type)
+	if f.Default != nil {
+		return fmt.Sprintf("FuncParam(%s, %s, %s)", f.Name, f.TypeString(), f.Default.String())
+	}
+
+	return fmt.Sprintf("FuncParam(%s, %s)", f.Name, f.TypeString())
+}
+
+// TypeString formats the type the way it is declared, `...i32` for variadic
+// parameters.
+func (f FuncParam) TypeString() string {
+	if f.IsVariadic {
+		return "..." + f.Type.Elems[0].String()
+	}
+
+	return f.Type.String()
 }
 
+func (t TypeParam) String() string {
+	if len(t.Bounds) == 0 {
+		return t.Name
+	}
+
+	return fmt.Sprintf("%s: %s", t.Name, strings.Join(t.Bounds, " + "))
+}
+
+// Signature formats the function the way it is declared, without its body,
+// e.g. `fun print(&Vec2) -> void`.
+func (f FuncDecl) Signature() string {
+	params := Map(f.Params, func(p FuncParam) string {
+		return p.TypeString()
+	})
+
+	retType := "void"
+	if f.ReturnType.Kind != NoType {
+		retType = f.ReturnType.String()
+	}
+
+	return fmt.Sprintf("fun %s%s(%s) -> %s", f.Name, typeParamsString(f.TypeParams), strings.Join(params, ", "), retType)
+}
+
+func typeParamsString(params []TypeParam) string {
+	if len(params) == 0 {
+		return ""
+	}
+
+	names := Map(params, func(t TypeParam) string {
+		return t.String()
+	})
+
+	return fmt.Sprintf("<%s>", strings.Join(names, ", "))
+}
+
+// Type is the function type of the declaration, e.g. `fun(i32) -> i32`.
+func (f FuncDecl) Type() TypeSpec {
+	params := Map(f.Params, func(p FuncParam) TypeSpec {
+		return p.Type
+	})
+
+	return FuncTypeSpec(params, f.ReturnType)
+}
+
+// Function literal
+
+// FuncLit is an anonymous function, either `fun(x: i32) -> i32 { ... }` or a
+// lambda like `|x| x * 2`. Lambda parameters may leave out their types when
+// they are known from where the lambda is used.
+type FuncLit struct {
+	Params     []FuncParam
+	ReturnType TypeSpec
+	Body       Block
+	// IsLambda is set for `|x| ...`, whose return type is inferred.
+	IsLambda bool
+	// IsExprBody is set for lambdas like `|x| x * 2`, whose body is a single
+	// return statement.
+	IsExprBody bool
+}
+
+func (f FuncLit) Accept(interp Visitor) AvaVal {
+	return interp.VisitFuncLit(f)
+}
+
+func (f FuncLit) String() string {
+	params := Map(f.Params, func(p FuncParam) string {
+		return p.String()
+	})
+
+	return fmt.Sprintf("FuncLit((%s), %s, %s)", strings.Join(params, ", "), f.ReturnType.String(), f.Body.String())
+}
+
+func (f FuncLit) exprNode() {}
+
 // If statement
 
 type IfStmt struct {
@@ -247,6 +610,7 @@ type IfStmt struct {
 	ThenBody  Block
 	HasElse   bool // remove if refactor to pointers
 	ElseBody  Block
+	Pos       Pos
 }
 
 func (i IfStmt) String() string {
@@ -264,10 +628,65 @@ func (i IfStmt) Accept(interp Visitor) AvaVal {
 
 func (i IfStmt) stmtNode() {}
 
+// Impl declaration statement
+
+type ImplDecl struct {
+	TypeParams []TypeParam
+	// Trait is empty for an inherent impl like `impl Vec2 { ... }`.
+	Trait   string
+	Target  TypeSpec
+	Methods []FuncDecl
+	Pos     Pos
+	// End is the position of the closing brace.
+	End Pos
+}
+
+func (i ImplDecl) Accept(interp Visitor) AvaVal {
+	return interp.VisitImplDecl(i)
+}
+
+func (i ImplDecl) String() string {
+	methods := Map(i.Methods, func(m FuncDecl) string {
+		return "\t" + strings.Replace(m.String(), "\n", "\n\t", -1)
+	})
+
+	target := i.Target.String()
+	if len(i.Trait) > 0 {
+		target = i.Trait + " for " + target
+	}
+
+	return fmt.Sprintf("ImplDecl%s(%s,\n%s)", typeParamsString(i.TypeParams), target, strings.Join(methods, ",\n"))
+}
+
+func (i ImplDecl) stmtNode() {}
+
+func (i ImplDecl) glblStmt() {}
+
+// Index expression
+
+type IndexExpr struct {
+	Expr  Expr
+	Index Expr
+	Pos   Pos
+}
+
+func (i IndexExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitIndexExpr(i)
+}
+
+func (i IndexExpr) String() string {
+	return fmt.Sprintf("IndexExpr(%s, %s)", i.Expr.String(), i.Index.String())
+}
+
+func (i IndexExpr) exprNode() {}
+
 // Integer literal
 
 type IntLit struct {
 	Value int
+	Pos   Pos
+	// Type is the type given by a suffix, e.g. `10u8`, or "" for i32.
+	Type string
 }
 
 func (i IntLit) Accept(interp Visitor) AvaVal {
@@ -284,6 +703,7 @@ func (i IntLit) exprNode() {}
 
 type LocStmt struct {
 	Value string
+	Pos   Pos
 }
 
 func (l LocStmt) Accept(interp Visitor) AvaVal {
@@ -296,6 +716,115 @@ func (l LocStmt) String() string {
 
 func (l LocStmt) stmtNode() {}
 
+// Map literal
+
+type MapLit struct {
+	Type   TypeSpec
+	Keys   []Expr
+	Values []Expr
+	Pos    Pos
+}
+
+func (m MapLit) Accept(interp Visitor) AvaVal {
+	return interp.VisitMapLit(m)
+}
+
+func (m MapLit) String() string {
+	entries := make([]string, len(m.Keys))
+	for i, key := range m.Keys {
+		entries[i] = fmt.Sprintf("%s: %s", key.String(), m.Values[i].String())
+	}
+
+	return fmt.Sprintf("MapLit(%s, %s)", m.Type.String(), strings.Join(entries, ", "))
+}
+
+func (m MapLit) exprNode() {}
+
+// Match expression
+
+type MatchExpr struct {
+	Subject Expr
+	Arms    []MatchArm
+	// End is the position of the closing brace.
+	End Pos
+}
+
+type MatchArm struct {
+	Pattern Pattern
+	// Guard is nil for arms without an `if` guard.
+	Guard Expr
+	// Body is either an Expr or a Block.
+	Body Node
+	Pos  Pos
+}
+
+func (m MatchExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitMatchExpr(m)
+}
+
+func (m MatchExpr) String() string {
+	arms := Map(m.Arms, func(arm MatchArm) string {
+		return "\t" + arm.String()
+	})
+
+	return fmt.Sprintf("MatchExpr(%s,\n%s)", m.Subject.String(), strings.Join(arms, ",\n"))
+}
+
+func (m MatchExpr) exprNode() {}
+
+func (a MatchArm) String() string {
+	guard := ""
+	if a.Guard != nil {
+		guard = ", " + a.Guard.String()
+	}
+
+	return fmt.Sprintf("MatchArm(%s%s, %s)", a.Pattern.String(), guard, a.Body.String())
+}
+
+// Method call expression
+
+type MethodCall struct {
+	Receiver Expr
+	Method   string
+	Args     []Expr
+	// Optional is set for `x?.method()`, which is nil when x is nil.
+	Optional bool
+	Pos      Pos
+}
+
+func (m MethodCall) Accept(interp Visitor) AvaVal {
+	return interp.VisitMethodCall(m)
+}
+
+func (m MethodCall) String() string {
+	args := Map(m.Args, func(arg Expr) string {
+		return ", " + arg.String()
+	})
+
+	method := m.Method
+	if m.Optional {
+		method = "?" + method
+	}
+
+	return fmt.Sprintf("MethodCall(%s, %s%s)", m.Receiver.String(), method, strings.Join(args, ""))
+}
+
+func (m MethodCall) exprNode() {}
+
+// Nil literal
+
+type NilLit struct{}
+
+func (n NilLit) Accept(interp Visitor) AvaVal {
+	return interp.VisitNilLit(n)
+}
+
+func (n NilLit) String() string {
+	return "NilLit"
+}
+
+func (n NilLit) exprNode() {}
+
 // Parens expression
 
 type ParenExpr struct {
@@ -312,6 +841,33 @@ func (p ParenExpr) String() string {
 
 func (p ParenExpr) exprNode() {}
 
+// Named argument, `port: 80` in `connect(host: "a", port: 80)`
+
+type NamedArg struct {
+	Name  string
+	Value Expr
+	Pos   Pos
+}
+
+func (n NamedArg) Accept(interp Visitor) AvaVal {
+	return interp.VisitNamedArg(n)
+}
+
+func (n NamedArg) String() string {
+	return fmt.Sprintf("NamedArg(%s, %s)", n.Name, n.Value.String())
+}
+
+func (n NamedArg) exprNode() {}
+
+// argValue returns the value of a call argument, which may be named.
+func argValue(arg Expr) Expr {
+	if named, ok := arg.(NamedArg); ok {
+		return named.Value
+	}
+
+	return arg
+}
+
 // Program statement
 
 type ProgStmt struct {
@@ -342,10 +898,90 @@ func (p ProgStmt) String() string {
 	return sb.String()
 }
 
+// Range expression
+
+type RangeExpr struct {
+	Start     Expr
+	End       Expr
+	Inclusive bool
+}
+
+func (r RangeExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitRangeExpr(r)
+}
+
+func (r RangeExpr) String() string {
+	op := ".."
+	if r.Inclusive {
+		op = "..="
+	}
+
+	return fmt.Sprintf("RangeExpr(%s, %s, %s)", r.Start.String(), op, r.End.String())
+}
+
+func (r RangeExpr) exprNode() {}
+
+// Recover expression
+
+// RecoverExpr is `try f(x)`, which is `Result::Ok` with the value of the call,
+// or `Result::Err` with the message when the call panics.
+type RecoverExpr struct {
+	Call Expr
+}
+
+func (r RecoverExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitRecoverExpr(r)
+}
+
+func (r RecoverExpr) String() string {
+	return fmt.Sprintf("RecoverExpr(%s)", r.Call.String())
+}
+
+func (r RecoverExpr) exprNode() {}
+
+// Reference expression
+
+type RefExpr struct {
+	Expr Expr
+}
+
+func (r RefExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitRefExpr(r)
+}
+
+func (r RefExpr) String() string {
+	return fmt.Sprintf("RefExpr(%s)", r.Expr.String())
+}
+
+func (r RefExpr) exprNode() {}
+
+// Return statement
+
+type ReturnStmt struct {
+	// Value is nil for a bare `return;`.
+	Value Expr
+	Pos   Pos
+}
+
+func (r ReturnStmt) String() string {
+	if r.Value == nil {
+		return "ReturnStmt()"
+	}
+
+	return fmt.Sprintf("ReturnStmt(%s)", r.Value.String())
+}
+
+func (r ReturnStmt) Accept(interp Visitor) AvaVal {
+	return interp.VisitReturnStmt(r)
+}
+
+func (r ReturnStmt) stmtNode() {}
+
 // String literal
 
 type StrLit struct {
 	Value string
+	Pos   Pos
 }
 
 func (s StrLit) Accept(interp Visitor) AvaVal {
@@ -358,26 +994,123 @@ func (s StrLit) String() string {
 
 func (s StrLit) exprNode() {}
 
+// Character literal
+
+type CharLit struct {
+	Value rune
+	Pos   Pos
+}
+
+func (c CharLit) Accept(interp Visitor) AvaVal {
+	return interp.VisitCharLit(c)
+}
+
+func (c CharLit) String() string {
+	return fmt.Sprintf("CharLit(%c)", c.Value)
+}
+
+func (c CharLit) exprNode() {}
+
+// Interpolated string, e.g. "n = {n}". Text is kept as StrLit parts.
+
+type InterpStr struct {
+	Parts []Expr
+	Pos   Pos
+}
+
+func (s InterpStr) Accept(interp Visitor) AvaVal {
+	return interp.VisitInterpStr(s)
+}
+
+func (s InterpStr) String() string {
+	parts := Map(s.Parts, func(part Expr) string {
+		return fmt.Sprint(part)
+	})
+
+	return fmt.Sprintf("InterpStr(%s)", strings.Join(parts, ", "))
+}
+
+func (s InterpStr) exprNode() {}
+
+// Struct literal
+
+type StructLit struct {
+	Name   string
+	Fields []StructLitField
+	Pos    Pos
+}
+
+type StructLitField struct {
+	Name  string
+	Value Expr
+}
+
+func (s StructLit) Accept(interp Visitor) AvaVal {
+	return interp.VisitStructLit(s)
+}
+
+func (s StructLit) String() string {
+	fields := Map(s.Fields, func(f StructLitField) string {
+		return fmt.Sprintf("%s: %s", f.Name, f.Value.String())
+	})
+
+	return fmt.Sprintf("StructLit(%s, %s)", s.Name, strings.Join(fields, ", "))
+}
+
+func (s StructLit) exprNode() {}
+
+// Type declaration, an alias like `type Meters = f64;` or a new type like
+// `type UserId = distinct i64;`
+
+type TypeDecl struct {
+	Name string
+	Type TypeSpec
+	// IsDistinct makes a new type, which does not mix with Type.
+	IsDistinct bool
+	Pos        Pos
+}
+
+func (t TypeDecl) String() string {
+	if t.IsDistinct {
+		return fmt.Sprintf("TypeDecl(%s, distinct %s)", t.Name, t.Type)
+	}
+
+	return fmt.Sprintf("TypeDecl(%s, %s)", t.Name, t.Type)
+}
+
+func (t TypeDecl) Accept(interp Visitor) AvaVal {
+	return interp.VisitTypeDecl(t)
+}
+
+func (t TypeDecl) stmtNode() {}
+
+func (t TypeDecl) glblStmt() {}
+
 // Struct declaration statement
 
 type StructDecl struct {
-	Name   string
-	Fields []StructField
+	Name       string
+	TypeParams []TypeParam
+	Fields     []StructField
+	Pos        Pos
+	// End is the position of the closing brace.
+	End Pos
 }
 
 type StructField struct {
 	Name string
-	Type string
+	Type TypeSpec
+	Pos  Pos
 }
 
 func (s StructDecl) String() string {
 	fields := make([]string, len(s.Fields))
 
 	for i, f := range s.Fields {
-		fields[i] = fmt.Sprintf("%s: %s", f.Name, f.Type)
+		fields[i] = fmt.Sprintf("%s: %s", f.Name, f.Type.String())
 	}
 
-	return fmt.Sprintf("StructDecl(%s, %s)", s.Name, strings.Join(fields, ", "))
+	return fmt.Sprintf("StructDecl(%s%s, %s)", s.Name, typeParamsString(s.TypeParams), strings.Join(fields, ", "))
 }
 
 func (s StructDecl) Accept(interp Visitor) AvaVal {
@@ -388,12 +1121,105 @@ func (s StructDecl) stmtNode() {}
 
 func (s StructDecl) glblStmt() {}
 
+// Trait declaration statement
+
+type TraitDecl struct {
+	Name string
+	// Methods only hold signatures, their bodies are empty.
+	Methods []FuncDecl
+	Pos     Pos
+	// End is the position of the closing brace.
+	End Pos
+}
+
+func (t TraitDecl) Accept(interp Visitor) AvaVal {
+	return interp.VisitTraitDecl(t)
+}
+
+func (t TraitDecl) String() string {
+	methods := Map(t.Methods, func(m FuncDecl) string {
+		return "\t" + m.Signature()
+	})
+
+	return fmt.Sprintf("TraitDecl(%s,\n%s)", t.Name, strings.Join(methods, ",\n"))
+}
+
+func (t TraitDecl) stmtNode() {}
+
+func (t TraitDecl) glblStmt() {}
+
+// Try expression
+
+// TryExpr is `expr?`, which unwraps a Result or returns its error from the
+// surrounding function.
+type TryExpr struct {
+	Expr Expr
+}
+
+func (t TryExpr) Accept(interp Visitor) AvaVal {
+	return interp.VisitTryExpr(t)
+}
+
+func (t TryExpr) String() string {
+	return fmt.Sprintf("TryExpr(%s)", t.Expr.String())
+}
+
+func (t TryExpr) exprNode() {}
+
+// Tuple literal
+
+type TupleLit struct {
+	Elems []Expr
+}
+
+func (t TupleLit) Accept(interp Visitor) AvaVal {
+	return interp.VisitTupleLit(t)
+}
+
+func (t TupleLit) String() string {
+	elems := Map(t.Elems, func(e Expr) string {
+		return e.String()
+	})
+
+	return fmt.Sprintf("TupleLit(%s)", strings.Join(elems, ", "))
+}
+
+func (t TupleLit) exprNode() {}
+
+// Tuple variable declaration statement
+
+type TupleVarDecl struct {
+	Names []string
+	Type  TypeSpec
+	Init  Expr
+	Pos   Pos
+}
+
+func (t TupleVarDecl) Accept(interp Visitor) AvaVal {
+	return interp.VisitTupleVarDecl(t)
+}
+
+func (t TupleVarDecl) String() string {
+	typ := t.Type.String()
+	if len(typ) == 0 {
+		typ = "?"
+	}
+
+	return fmt.Sprintf("TupleVarDecl((%s), %s, %s)", strings.Join(t.Names, ", "), typ, t.Init.String())
+}
+
+func (t TupleVarDecl) stmtNode() {}
+
+// The REPL keeps the variables of a TupleVarDecl as globals.
+func (t TupleVarDecl) glblStmt() {}
+
 // Variable declaration statement
 
 type VarDecl struct {
 	Name string
-	Type string
+	Type TypeSpec
 	Init Expr
+	Pos  Pos
 }
 
 func (v VarDecl) Accept(interp Visitor) AvaVal {
@@ -401,7 +1227,7 @@ func (v VarDecl) Accept(interp Visitor) AvaVal {
 }
 
 func (v VarDecl) String() string {
-	typ := v.Type
+	typ := v.Type.String()
 	if len(typ) == 0 {
 		typ = "?"
 	}
@@ -420,6 +1246,7 @@ func (v VarDecl) declNode() {}
 
 type Variable struct {
 	Name string
+	Pos  Pos
 }
 
 func (v Variable) Accept(interp Visitor) AvaVal {
@@ -438,6 +1265,7 @@ func (v Variable) exprNode() {
 type WhileStmt struct {
 	Condition Expr
 	Body      Block
+	Pos       Pos
 }
 
 func (w WhileStmt) String() string {
@@ -10,5 +10,38 @@ const (
 	Bool
 	Int
 	Struct
+	Array
+	Dict
+	Range
+	Tuple
+	Enum
+	Nil
+	Ref
+	Func
+	Char
 	Unknown
 )
+
+var avaTypeNames = []string{
+	"zero",
+	"void",
+	"float",
+	"string",
+	"bool",
+	"int",
+	"struct",
+	"array",
+	"map",
+	"range",
+	"tuple",
+	"enum",
+	"nil",
+	"reference",
+	"function",
+	"char",
+	"unknown",
+}
+
+func (t AvaType) String() string {
+	return avaTypeNames[t]
+}
@@ -1,6 +1,358 @@
 package main
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type AvaVal struct {
 	Type  AvaType
 	Value any
 }
+
+func (v AvaVal) String() string {
+	if v.Type == Nil {
+		return "nil"
+	} else if v.Type == Void {
+		return "void"
+	} else if v.Type == Char {
+		return string(v.Value.(rune))
+	}
+
+	return fmt.Sprint(v.Value)
+}
+
+// AvaMap keeps its entries in insertion order so iterating and printing a map is deterministic.
+type AvaMap struct {
+	Keys    []AvaVal
+	Entries map[any]AvaVal
+}
+
+func NewAvaMap() *AvaMap {
+	return &AvaMap{
+		Keys:    make([]AvaVal, 0),
+		Entries: make(map[any]AvaVal),
+	}
+}
+
+func (m *AvaMap) Get(key AvaVal) (AvaVal, bool) {
+	val, ok := m.Entries[hashKey(key)]
+	return val, ok
+}
+
+func (m *AvaMap) Set(key AvaVal, val AvaVal) {
+	if _, ok := m.Entries[hashKey(key)]; !ok {
+		m.Keys = append(m.Keys, key)
+	}
+	m.Entries[hashKey(key)] = val
+}
+
+func (m *AvaMap) String() string {
+	entries := Map(m.Keys, func(key AvaVal) string {
+		return fmt.Sprintf("%v:%v", key, m.Entries[hashKey(key)])
+	})
+
+	return fmt.Sprintf("map[%s]", strings.Join(entries, " "))
+}
+
+// hashKey returns the Go value a map entry is stored under. Tuples, structs,
+// enums and arrays are not comparable in Go, so they are keyed by a string
+// that is equal for equal values.
+func hashKey(key AvaVal) any {
+	switch key.Type {
+	case Tuple, Struct, Enum, Array:
+		return keyString(key)
+	}
+
+	return key.Value
+}
+
+func keyString(val AvaVal) string {
+	keys := func(vals []AvaVal) string {
+		return strings.Join(Map(vals, keyString), ",")
+	}
+
+	switch v := val.Value.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64) + "f"
+	case string:
+		return strconv.Quote(v)
+	case rune:
+		return strconv.QuoteRune(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case AvaTuple:
+		return "(" + keys(v) + ")"
+	case []AvaVal:
+		return "[" + keys(v) + "]"
+	case AvaEnum:
+		return v.Enum + "::" + v.Variant + "(" + keys(v.Fields) + ")"
+	case AvaStruct:
+		return v.Name + "{" + keys(Map(v.Fields, func(f AvaField) AvaVal {
+			return f.Value
+		})) + "}"
+	}
+
+	if val.Type == Nil {
+		return "nil"
+	}
+
+	runtimePanic("Value of type %s cannot be a map key", val.Type)
+	return ""
+}
+
+// AvaTuple is never modified in place, so tuple values can be shared freely.
+type AvaTuple []AvaVal
+
+func (t AvaTuple) String() string {
+	elems := Map(t, func(elem AvaVal) string {
+		return elem.String()
+	})
+
+	return fmt.Sprintf("(%s)", strings.Join(elems, ", "))
+}
+
+// AvaStruct is never modified in place, assigning to a field creates a new
+// struct. This gives structs value semantics.
+type AvaStruct struct {
+	Name   string
+	Fields []AvaField
+}
+
+type AvaField struct {
+	Name  string
+	Value AvaVal
+}
+
+func (s AvaStruct) Get(name string) (AvaVal, bool) {
+	for _, field := range s.Fields {
+		if field.Name == name {
+			return field.Value, true
+		}
+	}
+
+	return AvaVal{}, false
+}
+
+func (s AvaStruct) String() string {
+	fields := Map(s.Fields, func(f AvaField) string {
+		return fmt.Sprintf("%s: %v", f.Name, f.Value)
+	})
+
+	return fmt.Sprintf("%s { %s }", s.Name, strings.Join(fields, ", "))
+}
+
+type AvaEnum struct {
+	Enum    string
+	Variant string
+	Fields  AvaTuple
+}
+
+func (e AvaEnum) String() string {
+	if len(e.Fields) == 0 {
+		return e.Variant
+	}
+
+	return e.Variant + e.Fields.String()
+}
+
+// newResult creates a variant of the prelude's Result, `Ok` or `Err`.
+func newResult(variant string, val AvaVal) AvaVal {
+	return AvaVal{
+		Type: Enum,
+		Value: AvaEnum{
+			Enum:    "Result",
+			Variant: variant,
+			Fields:  AvaTuple{val},
+		},
+	}
+}
+
+// newError creates an Error of the prelude without a cause.
+func newError(message string) AvaVal {
+	return AvaVal{
+		Type: Struct,
+		Value: AvaStruct{
+			Name: "Error",
+			Fields: []AvaField{
+				{
+					Name: "message",
+					Value: AvaVal{
+						Type:  String,
+						Value: message,
+					},
+				},
+				{
+					Name: "cause",
+					Value: AvaVal{
+						Type: Nil,
+					},
+				},
+			},
+		},
+	}
+}
+
+// AvaRef points at the value of a variable, or at a field or element inside
+// of it, so writing through the reference changes the variable.
+type AvaRef struct {
+	Var *AvaVar
+	// Path leads from the value of Var to the referenced value. It holds
+	// field names for struct and tuple fields and AvaVals for indexes.
+	Path []any
+}
+
+func (r AvaRef) Get() AvaVal {
+	val := r.Var.Value
+	for _, step := range r.Path {
+		val = refStep(val, step)
+	}
+
+	return val
+}
+
+func (r AvaRef) Set(val AvaVal) {
+	r.Var.Value = refSet(r.Var.Value, r.Path, val)
+	r.Var.Type = r.Var.Value.Type
+}
+
+// Field returns a reference to a field or element of the referenced value.
+func (r AvaRef) Field(step any) AvaRef {
+	path := append(append([]any{}, r.Path...), step)
+
+	return AvaRef{
+		Var:  r.Var,
+		Path: path,
+	}
+}
+
+func (r AvaRef) String() string {
+	return r.Get().String()
+}
+
+func refStep(val AvaVal, step any) AvaVal {
+	switch val.Type {
+	case Struct:
+		if field, ok := val.Value.(AvaStruct).Get(step.(string)); ok {
+			return field
+		}
+	case Tuple:
+		elems := val.Value.(AvaTuple)
+		if k, err := strconv.Atoi(step.(string)); err == nil && k >= 0 && k < len(elems) {
+			return elems[k]
+		}
+	case Array:
+		elems := val.Value.([]AvaVal)
+		if k := step.(AvaVal).Value.(int); k >= 0 && k < len(elems) {
+			return elems[k]
+		}
+	case Dict:
+		if elem, ok := val.Value.(*AvaMap).Get(step.(AvaVal)); ok {
+			return elem
+		}
+	}
+
+	runtimePanic("Value of type %s has no field or element %v", val.Type, step)
+	return AvaVal{}
+}
+
+// cloneVal copies the arrays in val, which are otherwise changed in place.
+func cloneVal(val AvaVal) AvaVal {
+	switch v := val.Value.(type) {
+	case []AvaVal:
+		val.Value = Map(v, cloneVal)
+	case AvaTuple:
+		val.Value = AvaTuple(Map(v, cloneVal))
+	case AvaStruct:
+		fields := make([]AvaField, len(v.Fields))
+		for k, field := range v.Fields {
+			fields[k] = AvaField{
+				Name:  field.Name,
+				Value: cloneVal(field.Value),
+			}
+		}
+		val.Value = AvaStruct{
+			Name:   v.Name,
+			Fields: fields,
+		}
+	case AvaEnum:
+		v.Fields = Map(v.Fields, cloneVal)
+		val.Value = v
+	}
+
+	return val
+}
+
+// refSet returns val with the value at path replaced. Structs and tuples are
+// copied, arrays and maps are changed in place.
+func refSet(val AvaVal, path []any, elem AvaVal) AvaVal {
+	if len(path) == 0 {
+		return elem
+	}
+
+	step, rest := path[0], path[1:]
+
+	// Assigning to a missing key adds it to a map
+	old := AvaVal{}
+	if !(val.Type == Dict && len(rest) == 0) {
+		old = refStep(val, step)
+	}
+
+	switch val.Type {
+	case Struct:
+		s := val.Value.(AvaStruct)
+		fields := append([]AvaField{}, s.Fields...)
+		for k, field := range fields {
+			if field.Name == step.(string) {
+				fields[k].Value = refSet(old, rest, elem)
+			}
+		}
+
+		return AvaVal{
+			Type: Struct,
+			Value: AvaStruct{
+				Name:   s.Name,
+				Fields: fields,
+			},
+		}
+	case Tuple:
+		elems := append(AvaTuple{}, val.Value.(AvaTuple)...)
+		k, _ := strconv.Atoi(step.(string))
+		elems[k] = refSet(old, rest, elem)
+
+		return AvaVal{
+			Type:  Tuple,
+			Value: elems,
+		}
+	case Array:
+		val.Value.([]AvaVal)[step.(AvaVal).Value.(int)] = refSet(old, rest, elem)
+	case Dict:
+		val.Value.(*AvaMap).Set(step.(AvaVal), refSet(old, rest, elem))
+	}
+
+	return val
+}
+
+type AvaRange struct {
+	Start     int
+	End       int
+	Inclusive bool
+}
+
+// Last returns the last value produced by the range, which is before the start for empty ranges.
+func (r AvaRange) Last() int {
+	if r.Inclusive {
+		return r.End
+	}
+	return r.End - 1
+}
+
+func (r AvaRange) String() string {
+	if r.Inclusive {
+		return fmt.Sprintf("%d..=%d", r.Start, r.End)
+	}
+	return fmt.Sprintf("%d..%d", r.Start, r.End)
+}
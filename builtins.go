@@ -1,6 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 type AvaBuiltins struct {
 }
@@ -9,8 +17,168 @@ func (AvaBuiltins) Print(args ...any) {
 	fmt.Println(args...)
 }
 
+// Panic stops the program with a stack trace, unless it is caught by `try`.
+func (AvaBuiltins) Panic(message string) {
+	runtimePanic("%s", message)
+}
+
 func (AvaBuiltins) Input() string {
 	var str string
 	fmt.Scanln(&str)
 	return str
 }
+
+func (AvaBuiltins) ReadFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	return string(data), err
+}
+
+// WriteFile replaces the contents of a file and returns the number of bytes written.
+func (AvaBuiltins) WriteFile(path string, contents string) (int, error) {
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		return 0, err
+	}
+
+	return len(contents), nil
+}
+
+// Len returns the length of a string in bytes, or the number of elements in
+// an array or a map. Len(Chars(s)) counts the characters of a string.
+func (AvaBuiltins) Len(value any) int {
+	val := value.(AvaVal)
+
+	switch val.Type {
+	case String:
+		return len(val.Value.(string))
+	case Array:
+		return len(val.Value.([]AvaVal))
+	case Dict:
+		return len(val.Value.(*AvaMap).Keys)
+	}
+
+	runtimePanic("Value of type %s has no length", val.Type)
+	return 0
+}
+
+func (AvaBuiltins) Chars(s string) []rune {
+	return []rune(s)
+}
+
+func (AvaBuiltins) Upper(s string) string {
+	return strings.ToUpper(s)
+}
+
+func (AvaBuiltins) Lower(s string) string {
+	return strings.ToLower(s)
+}
+
+func (AvaBuiltins) Trim(s string) string {
+	return strings.TrimSpace(s)
+}
+
+func (AvaBuiltins) Split(s string, sep string) []string {
+	return strings.Split(s, sep)
+}
+
+func (AvaBuiltins) Join(parts []string, sep string) string {
+	return strings.Join(parts, sep)
+}
+
+func (AvaBuiltins) Contains(s string, sub string) bool {
+	return strings.Contains(s, sub)
+}
+
+// Find returns the character index of the first sub in s, or nil.
+func (AvaBuiltins) Find(s string, sub string) *int {
+	k := strings.Index(s, sub)
+	if k < 0 {
+		return nil
+	}
+
+	k = utf8.RuneCountInString(s[:k])
+	return &k
+}
+
+// Replace replaces all occurrences of old in s.
+func (AvaBuiltins) Replace(s string, old string, new string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+func (AvaBuiltins) ParseInt(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q", s)
+	}
+
+	return n, nil
+}
+
+func (AvaBuiltins) ParseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid float %q", s)
+	}
+
+	return f, nil
+}
+
+// OsBuiltins are the functions of the std::os module, which are called with
+// their full name like `std::os::exit(1)`.
+type OsBuiltins struct {
+}
+
+// Exit ends the program right away with the given exit code, deferred
+// expressions do not run.
+func (OsBuiltins) Exit(code int) {
+	os.Exit(code)
+}
+
+// Getenv returns the value of an environment variable, or nil when it is not set.
+func (OsBuiltins) Getenv(name string) *string {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	return &val
+}
+
+// Args returns the arguments given to the program after `--`, which main
+// also receives.
+func (OsBuiltins) Args() []string {
+	return programArgs
+}
+
+// builtinModules maps the name of a module to the type holding its builtins.
+var builtinModules = map[string]any{
+	"std::os": OsBuiltins{},
+}
+
+// builtinFunc finds the builtin called name. Builtins of modules are named
+// in lower case, `std::os::getenv` is OsBuiltins.Getenv.
+func builtinFunc(name string) (reflect.Value, bool) {
+	module, fun, ok := cutLast(name, "::")
+	if !ok {
+		m := reflect.ValueOf(AvaBuiltins{}).MethodByName(name)
+		return m, m.IsValid()
+	}
+
+	builtins, found := builtinModules[module]
+	first, _ := utf8.DecodeRuneInString(fun)
+	if !found || !unicode.IsLower(first) {
+		return reflect.Value{}, false
+	}
+
+	m := reflect.ValueOf(builtins).MethodByName(string(unicode.ToUpper(first)) + fun[1:])
+	return m, m.IsValid()
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s string, sep string) (before string, after string, found bool) {
+	k := strings.LastIndex(s, sep)
+	if k < 0 {
+		return s, "", false
+	}
+
+	return s[:k], s[k+len(sep):], true
+}
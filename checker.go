@@ -0,0 +1,2848 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// anyType is accepted everywhere. It is used for builtins taking any
+	// value and for expressions that already produced an error.
+	anyType   = NamedTypeSpec("any")
+	voidType  = NamedTypeSpec("void")
+	boolType  = NamedTypeSpec("bool")
+	strType   = NamedTypeSpec("str")
+	charType  = NamedTypeSpec("char")
+	intType   = NamedTypeSpec("i32")
+	floatType = NamedTypeSpec("f64")
+	rangeType = NamedTypeSpec("range")
+	nilType   = NamedTypeSpec("nil")
+	errorType = NamedTypeSpec("Error")
+)
+
+var intTypes = []string{
+	"u8", "i8",
+	"u16", "i16",
+	"u32", "i32",
+	"u64", "i64",
+}
+
+var floatTypes = []string{
+	"f32", "f64",
+}
+
+type checkedVar struct {
+	Type    TypeSpec
+	IsConst bool
+	// Narrowed is set when a nullable variable has been checked for nil, Type
+	// is then its type without the `?`.
+	Narrowed bool
+	// Value is where the value of a const is stored once it is evaluated.
+	Value *AvaVal
+}
+
+// Checker validates the types of a program before it is run. It keeps going
+// after an error, so all problems are reported at once.
+type Checker struct {
+	tree ProgStmt
+
+	environment *Environment[checkedVar]
+	functions   map[string]FuncDecl
+	structs     map[string]StructDecl
+	enums       map[string]EnumDecl
+	traits      map[string]TraitDecl
+	// methods maps a type name to the methods of its impl blocks. Their type
+	// parameters start with the type parameters of the impl block.
+	methods map[string]map[string]FuncDecl
+	// impls maps a type name to the traits it implements.
+	impls map[string][]string
+	// distinct maps a type declared with `type T = distinct U` to U, and
+	// aliases maps a type alias to the type it stands for.
+	distinct map[string]TypeSpec
+	aliases  map[string]TypeSpec
+
+	// function and returnType describe the function whose body is being checked.
+	function   string
+	returnType TypeSpec
+	// typeParams are the type parameters of the generic declaration being checked.
+	typeParams []string
+	// typeBounds maps a type parameter to the traits it is bound by.
+	typeBounds map[string][]string
+	// constFun is set while the body of a `const fun` is checked.
+	constFun bool
+	// closureDepth is the depth of the environment around the function
+	// literal being checked, variables in scopes below it are captured.
+	closureDepth int
+	// closureNils holds the captured variables that function literals of the
+	// function being checked may set to nil. Nil checks do not narrow them, as
+	// any call could run the literal.
+	closureNils map[string]bool
+
+	// consts are evaluated after the program is found to be free of errors.
+	consts []pendingConst
+
+	// globals holds the global variables and consts by name. A global is
+	// checked when it is first used, globalStates tells how far it is.
+	globals      map[string]GlblStmt
+	globalStates map[string]globalState
+	// global is the global whose initializer is being checked.
+	global string
+	// deps maps a global, a function or a method to the globals, functions
+	// and methods it uses.
+	deps map[string][]string
+	// initOrder holds the globals in the order they are initialized in.
+	initOrder []GlblStmt
+
+	errors []string
+}
+
+func NewChecker(tree ProgStmt) *Checker {
+	return &Checker{
+		tree:         tree,
+		environment:  NewEnvironment[checkedVar](),
+		functions:    make(map[string]FuncDecl),
+		structs:      make(map[string]StructDecl),
+		enums:        make(map[string]EnumDecl),
+		traits:       make(map[string]TraitDecl),
+		methods:      make(map[string]map[string]FuncDecl),
+		impls:        make(map[string][]string),
+		distinct:     make(map[string]TypeSpec),
+		aliases:      make(map[string]TypeSpec),
+		globals:      make(map[string]GlblStmt),
+		globalStates: make(map[string]globalState),
+		deps:         make(map[string][]string),
+		closureNils:  make(map[string]bool),
+		errors:       make([]string, 0),
+	}
+}
+
+func (c *Checker) Check() []string {
+	c.Visit(c.tree)
+	c.orderGlobals()
+	c.checkInit()
+	c.checkMain()
+	if len(c.errors) == 0 {
+		c.foldConsts()
+	}
+
+	return c.errors
+}
+
+func (c *Checker) errorf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if len(c.function) > 0 {
+		msg = fmt.Sprintf("In function %s: %s", c.function, msg)
+	}
+
+	c.errors = append(c.errors, msg)
+}
+
+func typed(typ TypeSpec) AvaVal {
+	return AvaVal{
+		Value: typ,
+	}
+}
+
+func (c *Checker) typeOf(expr Expr) TypeSpec {
+	return c.Visit(expr).Value.(TypeSpec)
+}
+
+func isAny(typ TypeSpec) bool {
+	return typ.Kind == NamedType && typ.Name == anyType.Name && !typ.IsRef
+}
+
+func isNil(typ TypeSpec) bool {
+	return typ.Kind == NamedType && typ.Name == nilType.Name
+}
+
+func isCharType(typ TypeSpec) bool {
+	return typ.Kind == NamedType && typ.Name == charType.Name && !typ.IsRef
+}
+
+func isIntType(typ TypeSpec) bool {
+	return typ.Kind == NamedType && !typ.IsRef && !typ.IsNullable && contains(intTypes, typ.Name)
+}
+
+// isInteger reports whether typ can index an array or bound a range, which
+// any integer type can.
+func isInteger(typ TypeSpec) bool {
+	return isAny(typ) || isIntType(typ)
+}
+
+func isFloatType(typ TypeSpec) bool {
+	return typ.Kind == NamedType && !typ.IsRef && !typ.IsNullable && contains(floatTypes, typ.Name)
+}
+
+func isStrType(typ TypeSpec) bool {
+	return typ.Kind == NamedType && !typ.IsRef && !typ.IsNullable && typ.Name == strType.Name
+}
+
+func (c *Checker) isTypeParam(typ TypeSpec) bool {
+	return typ.Kind == NamedType && !typ.IsRef && !typ.IsNullable && len(typ.Elems) == 0 && contains(c.typeParams, typ.Name)
+}
+
+// throughRef returns the type of a value used through a reference, which
+// must be checked for nil like the reference itself. Other types are only
+// checked for nil.
+func (c *Checker) throughRef(typ TypeSpec) TypeSpec {
+	return c.nonNull(Referent(c.nonNull(typ)))
+}
+
+// nonNull reports a nullable value that is used before it is checked for nil
+// and returns the type of the value without the `?`.
+func (c *Checker) nonNull(typ TypeSpec) TypeSpec {
+	if typ.IsNullable {
+		c.errorf("Value of nullable type %s must be checked for nil before use", typ)
+		typ.IsNullable = false
+	}
+
+	return typ
+}
+
+func isVoid(typ TypeSpec) bool {
+	return typ.Kind == NoType || (typ.Kind == NamedType && typ.Name == voidType.Name)
+}
+
+// assignable reports whether a value of type src can be stored where a dst is expected.
+// An integer fits in a wider integer type, a narrower one needs a conversion
+// like `u8(n)`, a literal fits in any. All float types are assignable to each
+// other.
+func (c *Checker) assignable(dst TypeSpec, src TypeSpec) bool {
+	if isAny(dst) || isAny(src) {
+		return true
+	}
+
+	if isNil(src) {
+		return dst.IsNullable || isNil(dst)
+	}
+
+	// A value fits in a nullable of its type, but a nullable value only fits
+	// where nil is allowed.
+	if src.IsNullable && !dst.IsNullable {
+		return false
+	}
+	dst.IsNullable, src.IsNullable = false, false
+
+	if isIntType(dst) && isIntType(src) {
+		return src.IsLiteral || widens(src.Name, dst.Name)
+	} else if isFloatType(dst) && isFloatType(src) {
+		return true
+	}
+
+	// A trait object holds any value whose type implements the trait.
+	if dst.Kind == DynType && src.Kind != DynType {
+		return dst.IsRef == src.IsRef && c.implements(src, dst.Name)
+	}
+
+	if dst.Kind != src.Kind || dst.IsRef != src.IsRef || dst.IsNullableTarget != src.IsNullableTarget {
+		return false
+	}
+
+	if dst.Kind == NamedType && dst.Name != src.Name {
+		return false
+	}
+
+	if len(dst.Elems) != len(src.Elems) {
+		return false
+	}
+
+	for k := range dst.Elems {
+		if !c.assignable(dst.Elems[k], src.Elems[k]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// widens reports whether every value of the integer type src fits in dst, so
+// it is converted without a cast.
+func widens(src string, dst string) bool {
+	if strings.HasPrefix(src, "i") && strings.HasPrefix(dst, "u") {
+		return false
+	} else if strings.HasPrefix(src, "u") && strings.HasPrefix(dst, "i") {
+		return intTypeMax[src] < intTypeMax[dst]
+	}
+
+	return intTypeMax[src] <= intTypeMax[dst]
+}
+
+// implements reports whether values of type typ have the methods of the trait.
+func (c *Checker) implements(typ TypeSpec, trait string) bool {
+	if isAny(typ) {
+		return true
+	}
+
+	typ.IsRef = false
+	switch {
+	case typ.Kind == DynType:
+		return typ.Name == trait
+	case c.isTypeParam(typ):
+		return contains(c.typeBounds[typ.Name], trait)
+	case typ.Kind == NamedType:
+		return contains(c.impls[typ.Name], trait) || contains(builtinTraits(c.underlying(typ)), trait)
+	}
+
+	return false
+}
+
+// builtinTraits returns the operator traits an intrinsic type implements
+// with its built-in operators.
+func builtinTraits(typ TypeSpec) []string {
+	switch {
+	case isIntType(typ):
+		return []string{"Add", "Sub", "Mul", "Div", "Rem", "Neg", "Eq", "Ord"}
+	case isFloatType(typ):
+		return []string{"Add", "Sub", "Mul", "Div", "Neg", "Eq", "Ord"}
+	case isCharType(typ):
+		return []string{"Eq", "Ord"}
+	case isStrType(typ):
+		return []string{"Add", "Eq"}
+	case typ.Kind == NamedType && typ.Name == boolType.Name && !typ.IsRef && !typ.IsNullable:
+		return []string{"Eq"}
+	}
+
+	return nil
+}
+
+// checkTypeSpec reports types that name neither an intrinsic type, a type
+// parameter nor a declared struct or enum, and generic types instantiated
+// with the wrong number of type arguments.
+func (c *Checker) checkTypeSpec(typ TypeSpec) {
+	for _, elem := range typ.Elems {
+		c.checkTypeSpec(elem)
+	}
+
+	if typ.Kind == DynType {
+		if _, ok := c.traits[typ.Name]; !ok {
+			c.errorf("Undefined trait %s", typ.Name)
+		}
+		return
+	}
+
+	if typ.Kind == MapType && !c.hashable(typ.Elems[0], make(map[string]bool)) {
+		c.errorf("Type %s cannot be a map key, it holds functions, maps, references or trait objects", typ.Elems[0])
+	}
+
+	if typ.Kind != NamedType {
+		return
+	}
+
+	expected := 0
+	if decl, ok := c.structs[typ.Name]; ok {
+		expected = len(decl.TypeParams)
+	} else if decl, ok := c.enums[typ.Name]; ok {
+		expected = len(decl.TypeParams)
+	} else if _, ok := c.distinct[typ.Name]; !ok && !(contains(intrinsicTypes, typ.Name) || typ.Name == boolType.Name || contains(c.typeParams, typ.Name)) {
+		c.errorf("Unknown type %s", typ.Name)
+		return
+	}
+
+	if len(typ.Elems) != expected {
+		c.errorf("Type %s expects %d type arguments, but got %d", typ.Name, expected, len(typ.Elems))
+	}
+}
+
+// hashable reports whether values of a type can be compared by their
+// contents, which map keys are. seen holds the structs and enums being
+// checked, so recursive types end.
+func (c *Checker) hashable(typ TypeSpec, seen map[string]bool) bool {
+	if typ.IsRef || typ.Kind == FuncType || typ.Kind == MapType || typ.Kind == DynType {
+		return false
+	}
+
+	for _, elem := range typ.Elems {
+		if !c.hashable(elem, seen) {
+			return false
+		}
+	}
+
+	if typ.Kind != NamedType || seen[typ.Name] {
+		return true
+	}
+	seen[typ.Name] = true
+
+	fields := make([]TypeSpec, 0)
+	if decl, ok := c.structs[typ.Name]; ok {
+		for _, field := range decl.Fields {
+			fields = append(fields, field.Type)
+		}
+	} else if decl, ok := c.enums[typ.Name]; ok {
+		for _, variant := range decl.Variants {
+			fields = append(fields, variant.Fields...)
+		}
+	} else if under, ok := c.distinct[typ.Name]; ok {
+		fields = append(fields, under)
+	}
+
+	for _, field := range fields {
+		if !c.hashable(field, seen) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// declareTypeParams puts the type parameters of a generic declaration in scope.
+func (c *Checker) declareTypeParams(params []TypeParam) {
+	c.typeParams = make([]string, 0)
+	c.typeBounds = make(map[string][]string)
+
+	for _, param := range params {
+		if contains(c.typeParams, param.Name) {
+			c.errorf("Duplicate type parameter %s", param.Name)
+		}
+		c.typeParams = append(c.typeParams, param.Name)
+
+		for _, bound := range param.Bounds {
+			if _, ok := c.traits[bound]; !ok {
+				c.errorf("Undefined trait %s", bound)
+			}
+		}
+		c.typeBounds[param.Name] = param.Bounds
+	}
+}
+
+// unify matches a parameter type against an argument type and records what
+// the type parameters in names stand for.
+func (c *Checker) unify(param TypeSpec, arg TypeSpec, names []string, subst map[string]TypeSpec) {
+	if isAny(arg) || isNil(arg) || arg.Kind == NoType {
+		return
+	}
+
+	if param.IsNullable {
+		param.IsNullable = false
+		arg.IsNullable = false
+	}
+
+	if param.Kind == NamedType && len(param.Elems) == 0 && contains(names, param.Name) {
+		if param.IsRef {
+			if !arg.IsRef {
+				return
+			}
+			arg = Referent(arg)
+		}
+
+		bound, ok := subst[param.Name]
+		if !ok || isAny(bound) || (bound.IsLiteral && isIntType(arg)) {
+			subst[param.Name] = arg
+		} else if !c.assignable(bound, arg) {
+			c.errorf("Type parameter %s cannot be both %s and %s", param.Name, bound, arg)
+		}
+		return
+	}
+
+	if param.Kind != arg.Kind || param.IsRef != arg.IsRef || param.Name != arg.Name {
+		return
+	}
+
+	for k := 0; k < len(param.Elems) && k < len(arg.Elems); k++ {
+		c.unify(param.Elems[k], arg.Elems[k], names, subst)
+	}
+}
+
+// inferTypeArgs works out the type arguments of a generic function or struct
+// from the types of the values passed in.
+func (c *Checker) inferTypeArgs(name string, typeParams []TypeParam, params []TypeSpec, args []TypeSpec) map[string]TypeSpec {
+	subst := make(map[string]TypeSpec)
+	for k := 0; k < len(params) && k < len(args); k++ {
+		c.unify(params[k], args[k], typeParamNames(typeParams), subst)
+	}
+
+	c.resolveTypeArgs(name, typeParams, subst)
+	return subst
+}
+
+// resolveTypeArgs reports type parameters that could not be inferred and type
+// arguments that do not implement the bounds of their parameter.
+func (c *Checker) resolveTypeArgs(name string, typeParams []TypeParam, subst map[string]TypeSpec) {
+	for _, param := range typeParams {
+		if _, ok := subst[param.Name]; !ok {
+			c.errorf("Cannot infer type parameter %s of %s", param.Name, name)
+			subst[param.Name] = anyType
+		}
+
+		for _, bound := range param.Bounds {
+			if !c.implements(subst[param.Name], bound) {
+				c.errorf("Type %s does not implement trait %s required by %s", subst[param.Name], bound, name)
+			}
+		}
+	}
+}
+
+func typeParamNames(params []TypeParam) []string {
+	return Map(params, func(t TypeParam) string {
+		return t.Name
+	})
+}
+
+// substitute replaces type parameters with the types they stand for.
+func substitute(typ TypeSpec, subst map[string]TypeSpec) TypeSpec {
+	if typ.Kind == NamedType && len(typ.Elems) == 0 {
+		if s, ok := subst[typ.Name]; ok {
+			if typ.IsRef && !s.IsRef {
+				s = RefTo(s)
+			}
+			s.IsNullable = s.IsNullable || typ.IsNullable
+			return s
+		}
+		return typ
+	}
+
+	res := typ
+	res.Elems = Map(typ.Elems, func(elem TypeSpec) TypeSpec {
+		return substitute(elem, subst)
+	})
+
+	return res
+}
+
+// structSubst maps the type parameters of a struct to the type arguments of typ.
+func structSubst(decl StructDecl, typ TypeSpec) map[string]TypeSpec {
+	return typeArgSubst(decl.TypeParams, typ)
+}
+
+// enumSubst maps the type parameters of an enum to the type arguments of typ.
+func enumSubst(decl EnumDecl, typ TypeSpec) map[string]TypeSpec {
+	return typeArgSubst(decl.TypeParams, typ)
+}
+
+func typeArgSubst(params []TypeParam, typ TypeSpec) map[string]TypeSpec {
+	subst := make(map[string]TypeSpec)
+
+	for k, param := range params {
+		subst[param.Name] = anyType
+		if k < len(typ.Elems) {
+			subst[param.Name] = typ.Elems[k]
+		}
+	}
+
+	return subst
+}
+
+func (c *Checker) Visit(node Node) AvaVal {
+	return node.Accept(c)
+}
+
+func (c *Checker) VisitProgStmt(stmt ProgStmt) AvaVal {
+	// Functions and structs can be used before they are declared.
+	for _, glbl := range stmt.Glbls {
+		switch decl := glbl.(type) {
+		case FuncDecl:
+			if _, ok := c.functions[decl.Name]; ok {
+				c.errorf("Function %s is already defined", decl.Name)
+			}
+			c.functions[decl.Name] = decl
+		case StructDecl:
+			if _, ok := c.structs[decl.Name]; ok {
+				c.errorf("Redefining struct %s is not allowed", decl.Name)
+			}
+			c.structs[decl.Name] = decl
+		case EnumDecl:
+			if _, ok := c.enums[decl.Name]; ok {
+				c.errorf("Redefining enum %s is not allowed", decl.Name)
+			}
+			c.enums[decl.Name] = decl
+		case TraitDecl:
+			if _, ok := c.traits[decl.Name]; ok {
+				c.errorf("Redefining trait %s is not allowed", decl.Name)
+			}
+			c.traits[decl.Name] = decl
+		case TypeDecl:
+			if c.isTypeName(decl.Name) {
+				c.errorf("Redefining type %s is not allowed", decl.Name)
+			}
+			if decl.IsDistinct {
+				c.distinct[decl.Name] = decl.Type
+			} else {
+				c.aliases[decl.Name] = decl.Type
+			}
+		}
+	}
+
+	// Methods can only be attached once all types are known.
+	for _, glbl := range stmt.Glbls {
+		if decl, ok := glbl.(ImplDecl); ok {
+			c.registerImpl(decl)
+		}
+	}
+
+	// Globals are initialized before main runs, so function bodies are checked
+	// after all globals have been declared. Globals can be used before they
+	// are declared, they are initialized in the order they depend on each other.
+	for _, glbl := range stmt.Glbls {
+		if name, ok := globalName(glbl); ok {
+			c.globals[name] = glbl
+		}
+	}
+
+	for _, glbl := range stmt.Glbls {
+		if name, ok := globalName(glbl); ok {
+			c.checkGlobal(name)
+		} else if !hasBody(glbl) {
+			c.Visit(glbl)
+		}
+	}
+
+	for _, glbl := range stmt.Glbls {
+		if hasBody(glbl) {
+			c.Visit(glbl)
+		}
+	}
+
+	return AvaVal{}
+}
+
+// hasBody reports whether a global declares functions with bodies to check.
+func hasBody(glbl GlblStmt) bool {
+	switch glbl.(type) {
+	case FuncDecl, ImplDecl:
+		return true
+	}
+
+	return false
+}
+
+func (c *Checker) registerImpl(decl ImplDecl) {
+	target := decl.Target.Name
+	if !c.canImpl(decl.Target) {
+		c.errorf("Cannot implement methods for type %s", decl.Target)
+		return
+	}
+
+	if len(decl.Trait) > 0 {
+		if _, ok := c.traits[decl.Trait]; !ok {
+			c.errorf("Undefined trait %s", decl.Trait)
+		} else if contains(c.impls[target], decl.Trait) {
+			c.errorf("Trait %s is already implemented for %s", decl.Trait, target)
+		} else {
+			c.impls[target] = append(c.impls[target], decl.Trait)
+		}
+	}
+
+	if _, ok := c.methods[target]; !ok {
+		c.methods[target] = make(map[string]FuncDecl)
+	}
+
+	for _, method := range decl.Methods {
+		if _, ok := c.methods[target][method.Name]; ok {
+			c.errorf("Method %s is already defined for %s", method.Name, target)
+			continue
+		}
+
+		if enum, ok := c.enums[target]; ok && contains(Map(enum.Variants, func(v EnumVariant) string {
+			return v.Name
+		}), method.Name) {
+			c.errorf("Method %s of %s has the same name as a variant", method.Name, target)
+		}
+
+		method.TypeParams = append(append([]TypeParam{}, decl.TypeParams...), method.TypeParams...)
+		c.methods[target][method.Name] = method
+	}
+}
+
+// canImpl reports whether methods can be attached to typ, which only works
+// for structs and enums.
+func (c *Checker) canImpl(typ TypeSpec) bool {
+	if typ.Kind != NamedType || typ.IsRef {
+		return false
+	}
+
+	_, isStruct := c.structs[typ.Name]
+	_, isEnum := c.enums[typ.Name]
+	return isStruct || isEnum
+}
+
+// selfSubst replaces `Self` in the signature of a trait method with typ.
+func selfSubst(decl FuncDecl, typ TypeSpec) FuncDecl {
+	subst := map[string]TypeSpec{
+		"Self": typ,
+	}
+
+	res := decl
+	res.Params = Map(decl.Params, func(p FuncParam) FuncParam {
+		p.Type = substitute(p.Type, subst)
+		return p
+	})
+	res.ReturnType = substitute(decl.ReturnType, subst)
+
+	return res
+}
+
+// findMethod looks up a method of a value of type typ. Trait objects and
+// bounded type parameters have the methods of their traits.
+func (c *Checker) findMethod(typ TypeSpec, name string) (FuncDecl, bool) {
+	typ = Referent(typ)
+
+	traits := make([]string, 0)
+	switch {
+	case typ.Kind == DynType:
+		traits = append(traits, typ.Name)
+	case c.isTypeParam(typ):
+		traits = c.typeBounds[typ.Name]
+	case typ.Kind == NamedType:
+		decl, ok := c.methods[typ.Name][name]
+		return decl, ok
+	}
+
+	for _, trait := range traits {
+		for _, method := range c.traits[trait].Methods {
+			if method.Name == name {
+				return selfSubst(method, typ), true
+			}
+		}
+	}
+
+	return FuncDecl{}, false
+}
+
+// findStaticMethod resolves a qualified name like `Vec2::new` to a method.
+func (c *Checker) findStaticMethod(name string) (FuncDecl, bool) {
+	typeName, methodName, ok := strings.Cut(name, "::")
+	if !ok {
+		return FuncDecl{}, false
+	}
+
+	decl, ok := c.methods[typeName][methodName]
+	return decl, ok
+}
+
+func isMethod(decl FuncDecl) bool {
+	return len(decl.Params) > 0 && decl.Params[0].Name == "self"
+}
+
+func (c *Checker) VisitTraitDecl(decl TraitDecl) AvaVal {
+	names := make([]string, 0)
+	for _, method := range decl.Methods {
+		if contains(names, method.Name) {
+			c.errorf("Trait %s has duplicate method %s", decl.Name, method.Name)
+		}
+		names = append(names, method.Name)
+
+		c.declareTypeParams(method.TypeParams)
+		c.typeParams = append(c.typeParams, "Self")
+
+		c.checkTypeSpec(method.ReturnType)
+		for _, param := range method.Params {
+			c.checkTypeSpec(param.Type)
+		}
+	}
+
+	c.typeParams = nil
+
+	return AvaVal{}
+}
+
+func (c *Checker) VisitImplDecl(decl ImplDecl) AvaVal {
+	if !c.canImpl(decl.Target) {
+		return AvaVal{}
+	}
+
+	if trait, ok := c.traits[decl.Trait]; ok {
+		c.checkTraitImpl(trait, decl)
+	}
+
+	for _, method := range decl.Methods {
+		method.TypeParams = append(append([]TypeParam{}, decl.TypeParams...), method.TypeParams...)
+		c.checkFunction(decl.Target.Name+"::"+method.Name, method)
+	}
+
+	return AvaVal{}
+}
+
+// checkTraitImpl checks that an impl block has exactly the methods of the
+// trait, with the signatures the trait declares.
+func (c *Checker) checkTraitImpl(trait TraitDecl, decl ImplDecl) {
+	methods := make(map[string]FuncDecl)
+	for _, method := range decl.Methods {
+		methods[method.Name] = method
+	}
+
+	for _, expected := range trait.Methods {
+		method, ok := methods[expected.Name]
+		if !ok {
+			c.errorf("Type %s is missing method %s of trait %s", decl.Target, expected.Name, trait.Name)
+			continue
+		}
+
+		expected = selfSubst(expected, decl.Target)
+		if method.Signature() != expected.Signature() {
+			c.errorf("Method %s of %s has signature `%s`, but trait %s expects `%s`", method.Name, decl.Target, method.Signature(), trait.Name, expected.Signature())
+		}
+	}
+
+	for _, method := range decl.Methods {
+		found := false
+		for _, expected := range trait.Methods {
+			found = found || expected.Name == method.Name
+		}
+
+		if !found {
+			c.errorf("Method %s is not a member of trait %s", method.Name, trait.Name)
+		}
+	}
+}
+
+func (c *Checker) VisitLocStmt(_ LocStmt) AvaVal {
+	return AvaVal{}
+}
+
+func (c *Checker) VisitParenExpr(expr ParenExpr) AvaVal {
+	return c.Visit(expr.Expr)
+}
+
+func (c *Checker) VisitNamedArg(arg NamedArg) AvaVal {
+	return c.Visit(arg.Value)
+}
+
+func (c *Checker) VisitBlock(block Block) AvaVal {
+	c.environment.EnterBlock()
+	for _, stmt := range block.Stmts {
+		c.Visit(stmt)
+	}
+	c.environment.ExitBlock()
+
+	return AvaVal{}
+}
+
+func (c *Checker) checkCondition(cond Expr) {
+	typ := c.typeOf(cond)
+	if !c.assignable(boolType, typ) {
+		c.errorf("Condition must be bool, but got %s", typ)
+	}
+}
+
+func (c *Checker) VisitIfStmt(stmt IfStmt) AvaVal {
+	c.checkCondition(stmt.Condition)
+
+	c.environment.EnterBlock()
+	c.narrow(nilChecks(stmt.Condition, true))
+	c.Visit(stmt.ThenBody)
+	c.environment.ExitBlock()
+
+	if stmt.HasElse {
+		c.environment.EnterBlock()
+		c.narrow(nilChecks(stmt.Condition, false))
+		c.Visit(stmt.ElseBody)
+		c.environment.ExitBlock()
+	}
+
+	// After `if x == nil { return; }` x is not nil in the rest of the block.
+	if terminates(stmt.ThenBody) {
+		c.narrow(nilChecks(stmt.Condition, false))
+	} else if stmt.HasElse && terminates(stmt.ElseBody) {
+		c.narrow(nilChecks(stmt.Condition, true))
+	}
+
+	return AvaVal{}
+}
+
+func (c *Checker) VisitWhileStmt(stmt WhileStmt) AvaVal {
+	c.checkCondition(stmt.Condition)
+
+	c.environment.EnterBlock()
+	c.narrow(nilChecks(stmt.Condition, true))
+	c.Visit(stmt.Body)
+	c.environment.ExitBlock()
+
+	return AvaVal{}
+}
+
+// nilChecks returns the variables that cannot be nil when cond evaluates to result.
+func nilChecks(cond Expr, result bool) []string {
+	switch e := cond.(type) {
+	case ParenExpr:
+		return nilChecks(e.Expr, result)
+	case FuncCall:
+		if !e.IsComparison {
+			return nil
+		}
+
+		switch {
+		case (e.Name == "!=" && result) || (e.Name == "==" && !result):
+			return comparedToNil(e.Args[0], e.Args[1])
+		case (e.Name == "&&" && result) || (e.Name == "||" && !result):
+			return append(nilChecks(e.Args[0], result), nilChecks(e.Args[1], result)...)
+		}
+	}
+
+	return nil
+}
+
+// comparedToNil returns the variable in a comparison like `x != nil`.
+func comparedToNil(a Expr, b Expr) []string {
+	if _, ok := a.(NilLit); ok {
+		a, b = b, a
+	}
+
+	v, isVar := a.(Variable)
+	if _, ok := b.(NilLit); !ok || !isVar {
+		return nil
+	}
+
+	return []string{v.Name}
+}
+
+// narrow marks nullable variables that were checked for nil as not nil in the
+// current scope. Globals are not narrowed, any call could set them to nil.
+func (c *Checker) narrow(names []string) {
+	for _, name := range names {
+		v, ok := c.environment.Lookup(name)
+		if !ok || !v.Type.IsNullable || c.environment.IsGlobal(name) || c.closureNils[name] {
+			continue
+		}
+
+		v.Type.IsNullable = false
+		v.Narrowed = true
+		c.environment.DeclareAssign(name, v)
+	}
+}
+
+func (c *Checker) VisitForStmt(stmt ForStmt) AvaVal {
+	typ := c.throughRef(c.typeOf(stmt.Iterable))
+
+	key, value := anyType, anyType
+	switch {
+	case isAny(typ):
+	case typ.Kind == SliceType:
+		key, value = intType, typ.Elems[0]
+	case typ.Kind == MapType:
+		key, value = typ.Elems[0], typ.Elems[1]
+		if len(stmt.Key) == 0 {
+			value = key
+		}
+	case typ.Kind == NamedType && typ.Name == strType.Name:
+		key, value = intType, charType
+	case typ.Kind == NamedType && typ.Name == rangeType.Name:
+		key, value = intType, intType
+	default:
+		c.errorf("Cannot iterate over value of type %s", typ)
+	}
+
+	c.environment.EnterBlock()
+	if len(stmt.Key) > 0 {
+		c.environment.DeclareAssign(stmt.Key, checkedVar{
+			Type: concrete(key),
+		})
+	}
+	c.environment.DeclareAssign(stmt.Value, checkedVar{
+		Type: concrete(value),
+	})
+	c.Visit(stmt.Body)
+	c.environment.ExitBlock()
+
+	return AvaVal{}
+}
+
+func (c *Checker) VisitTypeDecl(decl TypeDecl) AvaVal {
+	c.checkTypeSpec(decl.Type)
+
+	return AvaVal{}
+}
+
+// isTypeName reports whether name is already used by a type.
+func (c *Checker) isTypeName(name string) bool {
+	_, isStruct := c.structs[name]
+	_, isEnum := c.enums[name]
+	_, isDistinct := c.distinct[name]
+	_, isAlias := c.aliases[name]
+
+	return isStruct || isEnum || isDistinct || isAlias || contains(intrinsicTypes, name)
+}
+
+// underlying returns the type a distinct type is made from.
+func (c *Checker) underlying(typ TypeSpec) TypeSpec {
+	for typ.Kind == NamedType && !typ.IsRef && !typ.IsNullable {
+		next, ok := c.distinct[typ.Name]
+		if !ok {
+			break
+		}
+		typ = next
+	}
+
+	return typ
+}
+
+// conversion returns the type a call named name converts its argument to,
+// e.g. `UserId(5)` or `f64(n)`.
+func (c *Checker) conversion(name string) (TypeSpec, bool) {
+	if typ, ok := c.aliases[name]; ok {
+		return typ, true
+	}
+
+	if _, ok := c.distinct[name]; ok || contains(intTypes, name) || contains(floatTypes, name) {
+		return NamedTypeSpec(name), true
+	}
+
+	return TypeSpec{}, false
+}
+
+// checkConversion allows converting between a distinct type and the type it
+// is made from, and between numbers.
+func (c *Checker) checkConversion(name string, typ TypeSpec, types []TypeSpec) TypeSpec {
+	if len(types) != 1 {
+		c.errorf("Conversion to %s expects 1 argument, but got %d", name, len(types))
+		return typ
+	}
+
+	dst, src := c.underlying(typ), c.underlying(c.nonNull(types[0]))
+	numeric := (isIntType(dst) || isFloatType(dst)) && (isIntType(src) || isFloatType(src))
+	if !numeric && !c.assignable(dst, src) {
+		c.errorf("Cannot convert %s to %s", types[0], name)
+	}
+
+	return typ
+}
+
+func (c *Checker) VisitStructDecl(decl StructDecl) AvaVal {
+	c.declareTypeParams(decl.TypeParams)
+
+	names := make([]string, 0)
+	for _, field := range decl.Fields {
+		if contains(names, field.Name) {
+			c.errorf("Struct %s has duplicate field %s", decl.Name, field.Name)
+		}
+		names = append(names, field.Name)
+
+		c.checkTypeSpec(field.Type)
+	}
+
+	c.typeParams = nil
+
+	return AvaVal{}
+}
+
+func (c *Checker) VisitEnumDecl(decl EnumDecl) AvaVal {
+	c.declareTypeParams(decl.TypeParams)
+
+	names := make([]string, 0)
+	for _, variant := range decl.Variants {
+		if contains(names, variant.Name) {
+			c.errorf("Enum %s has duplicate variant %s", decl.Name, variant.Name)
+		}
+		names = append(names, variant.Name)
+
+		for _, field := range variant.Fields {
+			c.checkTypeSpec(field)
+		}
+	}
+
+	c.typeParams = nil
+
+	return AvaVal{}
+}
+
+func (c *Checker) VisitAssignStmt(stmt AssignStmt) AvaVal {
+	expected := TypeSpec{}
+	variable, ok := stmt.Target.(Variable)
+	v, found := c.environment.Lookup(variable.Name)
+	if ok && found {
+		expected = v.Type
+	}
+
+	var typ TypeSpec
+	if stmt.Op == "" {
+		typ = c.typeOfAs(stmt.Value, expected)
+	} else {
+		// `a += b` stores the result of `a + b`
+		target := anyType
+		if !ok {
+			target = c.typeOf(stmt.Target)
+		} else if found {
+			target = expected
+		}
+		call := FuncCall{
+			Name:         stmt.Op,
+			IsArithmetic: true,
+			Args:         []Expr{stmt.Target, stmt.Value},
+			Pos:          stmt.Pos,
+		}
+		if method, isOp := c.operatorCall(call, target); isOp {
+			typ = c.typeOf(method)
+		} else {
+			typ = c.arithmeticType(stmt.Op, c.nonNull(target), c.nonNull(c.typeOf(stmt.Value)))
+		}
+	}
+
+	if !ok {
+		c.checkAssignTarget(stmt.Target, typ)
+		return AvaVal{}
+	}
+
+	if !found {
+		c.errorf("Variable %s is not declared", variable.Name)
+		return AvaVal{}
+	}
+
+	if v.IsConst {
+		c.errorf("Assignment to constant variable %s", variable.Name)
+	}
+
+	declared := v.Type
+	if v.Narrowed {
+		declared.IsNullable = true
+	}
+
+	if !c.assignable(declared, typ) {
+		c.errorf("Cannot assign value of type %s to variable %s of type %s", typ, variable.Name, declared)
+	}
+
+	// A value that may be nil undoes the nil check, for good when a function
+	// literal stores it in a variable it captures.
+	if typ.IsNullable || isNil(typ) {
+		if c.environment.ScopeOf(variable.Name) < c.closureDepth {
+			c.closureNils[variable.Name] = true
+		}
+		if v.Narrowed {
+			v.Type = declared
+			v.Narrowed = false
+			c.environment.Assign(variable.Name, v)
+		}
+	}
+
+	return AvaVal{}
+}
+
+// checkAssignTarget checks an assignment to a field, an element or through a reference.
+func (c *Checker) checkAssignTarget(target Expr, typ TypeSpec) {
+	if root, ok := placeRoot(target); !ok {
+		c.errorf("Cannot assign to a temporary value")
+	} else if v, found := c.environment.Lookup(root); found && v.IsConst {
+		c.errorf("Assignment to constant variable %s", root)
+	}
+
+	targetType := c.typeOf(target)
+	if !c.assignable(targetType, typ) {
+		c.errorf("Cannot assign value of type %s to a target of type %s", typ, targetType)
+	}
+}
+
+// placeRoot returns the variable that an assignment target or a reference
+// points into. root is empty behind a dereference, which always points to a
+// variable, and ok is false for values that are not stored anywhere, like the
+// result of a call.
+func placeRoot(expr Expr) (root string, ok bool) {
+	switch e := expr.(type) {
+	case ParenExpr:
+		return placeRoot(e.Expr)
+	case Variable:
+		return e.Name, !strings.Contains(e.Name, "::")
+	case FieldExpr:
+		if !e.Optional {
+			return placeRoot(e.Expr)
+		}
+	case IndexExpr:
+		return placeRoot(e.Expr)
+	case DerefExpr:
+		return "", true
+	}
+
+	return "", false
+}
+
+func (c *Checker) VisitExprStmt(stmt ExprStmt) AvaVal {
+	c.Visit(stmt.Expr)
+	return AvaVal{}
+}
+
+func (c *Checker) VisitDeferStmt(stmt DeferStmt) AvaVal {
+	if c.function == "" {
+		c.errorf("defer can only be used inside of a function")
+	}
+
+	c.Visit(stmt.Expr)
+	return AvaVal{}
+}
+
+func (c *Checker) VisitReturnStmt(stmt ReturnStmt) AvaVal {
+	if stmt.Value == nil {
+		if !isVoid(c.returnType) {
+			c.errorf("Missing return value of type %s", c.returnType)
+		}
+		return AvaVal{}
+	}
+
+	typ := c.typeOfAs(stmt.Value, c.returnType)
+	if isVoid(c.returnType) {
+		c.errorf("Function returning void cannot return a value of type %s", typ)
+	} else if !c.assignable(c.returnType, typ) {
+		c.errorf("Cannot return value of type %s from function returning %s", typ, c.returnType)
+	}
+
+	return AvaVal{}
+}
+
+// operatorTrait is the prelude trait that overloads an operator for structs
+// and enums, and the method it is implemented with.
+type operatorTrait struct {
+	Trait  string
+	Method string
+}
+
+var operatorTraits = map[string]operatorTrait{
+	"+":  {"Add", "add"},
+	"-":  {"Sub", "sub"},
+	"*":  {"Mul", "mul"},
+	"/":  {"Div", "div"},
+	"%":  {"Rem", "rem"},
+	"==": {"Eq", "eq"},
+	"!=": {"Eq", "eq"},
+	"<":  {"Ord", "lt"},
+	">":  {"Ord", "lt"},
+	"<=": {"Ord", "lt"},
+	">=": {"Ord", "lt"},
+}
+
+// operatorCall returns the method call an operator on a struct or enum stands
+// for, when the type implements the trait of the operator.
+func (c *Checker) operatorCall(call FuncCall, typ TypeSpec) (MethodCall, bool) {
+	op, ok := operatorTraits[call.Name]
+	if len(call.Args) == 1 {
+		op, ok = operatorTrait{"Neg", "neg"}, call.Name == "-"
+	}
+
+	typ.IsRef = false
+	if !ok || typ.IsNullable || !c.isUserType(typ) || !c.implements(typ, op.Trait) {
+		return MethodCall{}, false
+	}
+
+	return MethodCall{
+		Receiver: call.Args[0],
+		Method:   op.Method,
+		Args:     call.Args[1:],
+		Pos:      call.Pos,
+	}, true
+}
+
+// isUserType reports whether typ is a struct or an enum.
+func (c *Checker) isUserType(typ TypeSpec) bool {
+	_, isStruct := c.structs[typ.Name]
+	_, isEnum := c.enums[typ.Name]
+
+	return typ.Kind == NamedType && (isStruct || isEnum)
+}
+
+func (c *Checker) visitArithmeticCall(call FuncCall) AvaVal {
+	if lit, ok := call.Args[0].(IntLit); ok && isNegation(call) {
+		return typed(c.intLitType(lit, true))
+	}
+
+	types := Map(Map(call.Args, c.typeOf), c.nonNull)
+	if method, ok := c.operatorCall(call, types[0]); ok {
+		return c.Visit(method)
+	}
+
+	if len(types) == 1 {
+		if c.isTypeParam(types[0]) && c.implements(types[0], "Neg") {
+			return typed(types[0])
+		} else if c.isUserType(types[0]) || c.isTypeParam(types[0]) {
+			c.errorf("Operator %s cannot be applied to %s, it does not implement Neg", call.Name, types[0])
+			return typed(anyType)
+		} else if under := c.underlying(types[0]); !isAny(under) && !isIntType(under) && !isFloatType(under) {
+			c.errorf("Operator %s cannot be applied to %s", call.Name, types[0])
+			return typed(anyType)
+		}
+		return typed(types[0])
+	}
+
+	return typed(c.arithmeticType(call.Name, types[0], types[1]))
+}
+
+// arithmeticType returns the type of `a op b`, which is also checked for
+// compound assignments.
+func (c *Checker) arithmeticType(op string, a TypeSpec, b TypeSpec) TypeSpec {
+	if isAny(a) {
+		return b
+	} else if isAny(b) {
+		return a
+	}
+
+	// Operators on type parameters need a bound providing them.
+	if trait, found := operatorTraits[op]; found && c.isTypeParam(a) && c.assignable(a, b) && c.implements(a, trait.Trait) {
+		return a
+	}
+
+	// A distinct type has the operators of the type it is made from, but
+	// both sides must have the distinct type.
+	if under := c.underlying(a); under.Name != a.Name && c.assignable(a, b) {
+		if isAny(c.arithmeticType(op, under, under)) {
+			return anyType
+		}
+		return a
+	}
+
+	// A literal takes the type of the other side, like the 1 in `n + 1`,
+	// otherwise the narrower side is widened.
+	if isIntType(a) && isIntType(b) {
+		switch {
+		case b.IsLiteral || (!a.IsLiteral && widens(b.Name, a.Name)):
+			return a
+		case a.IsLiteral || widens(a.Name, b.Name):
+			return b
+		}
+		c.errorf("Operator %s cannot be applied to %s and %s, convert one of them", op, a, b)
+		return anyType
+	}
+
+	ok := (isIntType(a) && isIntType(b)) || (isFloatType(a) && isFloatType(b) && contains([]string{"+", "-", "*", "/"}, op))
+	// `+` concatenates strings
+	if op == "+" && isStrType(a) && isStrType(b) {
+		ok = true
+	}
+	// `&`, `|` and `^` are logical on bools
+	if contains([]string{"&", "|", "^"}, op) && c.assignable(boolType, a) && c.assignable(boolType, b) {
+		ok = true
+	}
+
+	if !ok && (c.isUserType(a) || c.isTypeParam(a)) {
+		if trait, found := operatorTraits[op]; found {
+			c.errorf("Operator %s cannot be applied to %s, it does not implement %s", op, a, trait.Trait)
+			return anyType
+		}
+	}
+
+	if !ok {
+		c.errorf("Operator %s cannot be applied to %s and %s", op, a, b)
+		return anyType
+	}
+
+	return a
+}
+
+func (c *Checker) visitComparisonCall(call FuncCall) AvaVal {
+	a := c.typeOf(call.Args[0])
+
+	// The right side of `x != nil && x.ok` only runs when x is not nil.
+	c.environment.EnterBlock()
+	if call.Name == "&&" {
+		c.narrow(nilChecks(call.Args[0], true))
+	} else if call.Name == "||" {
+		c.narrow(nilChecks(call.Args[0], false))
+	}
+	b := c.typeOf(call.Args[1])
+	c.environment.ExitBlock()
+
+	if method, ok := c.operatorCall(call, a); ok {
+		if typ := c.typeOf(method); !c.assignable(boolType, typ) {
+			c.errorf("Method %s must return bool to be used as %s, but returns %s", method.Method, call.Name, typ)
+		}
+		return typed(boolType)
+	}
+
+	ok := true
+	switch call.Name {
+	case "==", "!=":
+		ok = c.assignable(a, b) || c.assignable(b, a)
+	case "<", ">", "<=", ">=":
+		a, b = c.nonNull(a), c.nonNull(b)
+		if c.assignable(a, b) {
+			a, b = c.underlying(a), c.underlying(b)
+		}
+		ok = isAny(a) || isAny(b) || (isIntType(a) && isIntType(b)) || (isFloatType(a) && isFloatType(b)) ||
+			(isCharType(a) && isCharType(b)) || (c.isTypeParam(a) && c.assignable(a, b) && c.implements(a, "Ord"))
+	default:
+		ok = c.assignable(boolType, a) && c.assignable(boolType, b)
+	}
+
+	if !ok && (c.isUserType(a) || c.isTypeParam(a)) {
+		c.errorf("Operator %s cannot be applied to %s, it does not implement %s", call.Name, a, operatorTraits[call.Name].Trait)
+	} else if !ok {
+		c.errorf("Operator %s cannot be applied to %s and %s", call.Name, a, b)
+	}
+
+	return typed(boolType)
+}
+
+func (c *Checker) VisitFuncCall(call FuncCall) AvaVal {
+	if call.IsArithmetic {
+		return c.visitArithmeticCall(call)
+	} else if call.IsComparison {
+		return c.visitComparisonCall(call)
+	}
+
+	types := c.argTypes(call.Args)
+
+	// Variables holding a function shadow functions of the same name
+	if v, ok := c.lookup(call.Name); ok {
+		c.checkConstCall(call.Name)
+		return typed(c.callValue(call.Name, v.Type, call.Args, types))
+	}
+
+	if decl, ok := c.functions[call.Name]; ok {
+		if !decl.IsConst {
+			c.checkConstCall(call.Name)
+		}
+		if call.Name == "init" {
+			c.errorf("Function init runs before main and cannot be called")
+		}
+		c.dependOn(call.Name)
+		return typed(c.checkCall(call.Name, decl, call.Args, types))
+	}
+
+	if decl, ok := c.findStaticMethod(call.Name); ok {
+		c.checkConstCall(call.Name)
+		c.dependOn(call.Name)
+		return typed(c.checkCall(call.Name, decl, call.Args, types))
+	}
+
+	c.checkPositional(call.Name, call.Args)
+
+	if enum, variant, ok := c.findVariant(call.Name); ok {
+		c.checkLambdaArgs(call.Args, types, variant.Fields, nil, nil)
+		return c.checkVariant(enum, variant, types)
+	}
+
+	if typ, ok := c.conversion(call.Name); ok {
+		return typed(c.checkConversion(call.Name, typ, types))
+	}
+
+	c.checkConstCall(call.Name)
+	c.checkLambdaArgs(call.Args, types, nil, nil, nil)
+	return c.visitBuiltInCall(call, types)
+}
+
+func (c *Checker) VisitCallExpr(call CallExpr) AvaVal {
+	c.checkConstCall("a function value")
+	typ := c.typeOf(call.Callee)
+	types := c.argTypes(call.Args)
+
+	return typed(c.callValue("value", typ, call.Args, types))
+}
+
+// callValue checks a call of a function value and returns the type of its result.
+func (c *Checker) callValue(name string, typ TypeSpec, args []Expr, types []TypeSpec) TypeSpec {
+	typ = c.throughRef(typ)
+
+	if isAny(typ) || typ.Kind != FuncType {
+		if !isAny(typ) {
+			c.errorf("Cannot call %s of type %s", name, typ)
+		}
+		c.checkLambdaArgs(args, types, nil, nil, nil)
+		return anyType
+	}
+
+	params := typ.Params()
+	c.checkLambdaArgs(args, types, params, nil, nil)
+	c.checkPositional(name, args)
+
+	if len(types) != len(params) {
+		c.errorf("Function %s expects %d arguments, but got %d", name, len(params), len(types))
+	} else {
+		for k, param := range params {
+			if !c.assignable(param, types[k]) {
+				c.errorf("Argument %d of %s must be %s, but got %s", k+1, name, param, types[k])
+			} else {
+				c.checkIntRange(param, args[k])
+			}
+		}
+	}
+
+	return typ.Return()
+}
+
+// argTypes returns the types of the arguments of a call. Lambdas that need
+// the parameter types of the callee to be checked are left as NoType, they
+// are checked by checkLambdaArgs.
+func (c *Checker) argTypes(args []Expr) []TypeSpec {
+	return Map(args, func(arg Expr) TypeSpec {
+		if isUntypedLambda(argValue(arg)) {
+			return TypeSpec{}
+		}
+		return c.typeOf(arg)
+	})
+}
+
+// checkLambdaArgs checks the lambdas left out by argTypes against the types
+// of the parameters they are passed to. subst holds the type arguments
+// inferred from the other arguments and is extended by what the lambdas return.
+func (c *Checker) checkLambdaArgs(args []Expr, types []TypeSpec, params []TypeSpec, names []string, subst map[string]TypeSpec) {
+	for k, typ := range types {
+		if typ.Kind != NoType {
+			continue
+		}
+
+		expected := TypeSpec{}
+		if k < len(params) {
+			// Type parameters that are not known yet accept anything
+			open := make(map[string]TypeSpec)
+			for _, name := range names {
+				open[name] = anyType
+				if s, ok := subst[name]; ok {
+					open[name] = s
+				}
+			}
+			expected = substitute(params[k], open)
+		}
+
+		types[k] = c.checkFuncLit(argValue(args[k]).(FuncLit), expected)
+		if k < len(params) {
+			c.unify(params[k], types[k], names, subst)
+		}
+	}
+}
+
+// typeOfAs is typeOf for a value used where a value of type expected goes, so
+// lambdas can take the types of their parameters from it, struct literals
+// their type arguments, and integer literals are checked to fit in it.
+func (c *Checker) typeOfAs(expr Expr, expected TypeSpec) TypeSpec {
+	if lit, ok := expr.(FuncLit); ok && isUntypedLambda(expr) {
+		return c.checkFuncLit(lit, expected)
+	} else if lit, ok := expr.(StructLit); ok {
+		return c.checkStructLit(lit, expected)
+	}
+
+	typ := c.typeOf(expr)
+	c.checkIntRange(expected, expr)
+
+	return typ
+}
+
+// checkCall checks the arguments of a call to a declared function and
+// returns the type of its result.
+func (c *Checker) checkCall(name string, decl FuncDecl, args []Expr, types []TypeSpec) TypeSpec {
+	returnType := decl.ReturnType
+
+	// The receiver of a method call is passed as self, but is not counted as an argument.
+	self := 0
+	if isMethod(decl) && !strings.Contains(name, "::") {
+		self = 1
+	}
+
+	slots, err := bindArgs(name, decl.Params[self:], args[self:])
+	if err != nil {
+		c.errorf("%s", err)
+		c.checkLambdaArgs(args, types, nil, nil, nil)
+		if len(decl.TypeParams) > 0 {
+			return anyType
+		}
+		return c.callResult(returnType)
+	}
+
+	// params holds the type of the parameter of each argument, the elements
+	// of a variadic parameter take one argument each.
+	params := make([]TypeSpec, len(args))
+	for k := range args {
+		slot := k
+		if k >= self {
+			slot = slots[k-self] + self
+		}
+
+		param := decl.Params[slot]
+		params[k] = param.Type
+		if param.IsVariadic {
+			params[k] = param.Type.Elems[0]
+		}
+	}
+
+	// Lambdas are checked once the other arguments tell what their
+	// parameters are, e.g. `apply(xs, |x| x * 2)`.
+	names := typeParamNames(decl.TypeParams)
+	subst := make(map[string]TypeSpec)
+	for k := 0; k < len(params) && k < len(types); k++ {
+		c.unify(params[k], types[k], names, subst)
+	}
+	c.checkLambdaArgs(args, types, params, names, subst)
+
+	if len(decl.TypeParams) > 0 {
+		c.resolveTypeArgs(name, decl.TypeParams, subst)
+		params = Map(params, func(p TypeSpec) TypeSpec {
+			return substitute(p, subst)
+		})
+		returnType = substitute(returnType, subst)
+	}
+
+	for k, param := range params {
+		if c.assignable(param, types[k]) {
+			c.checkIntRange(param, argValue(args[k]))
+			continue
+		}
+
+		if k < self {
+			c.errorf("Method %s cannot be called on %s", name, types[k])
+		} else if named, ok := args[k].(NamedArg); ok {
+			c.errorf("Argument %s of %s must be %s, but got %s", named.Name, name, param, types[k])
+		} else {
+			c.errorf("Argument %d of %s must be %s, but got %s", k+1-self, name, param, types[k])
+		}
+	}
+
+	return c.callResult(returnType)
+}
+
+// callResult is the type of a call to a function returning returnType.
+func (c *Checker) callResult(returnType TypeSpec) TypeSpec {
+	if returnType.Kind == NoType {
+		return voidType
+	}
+	return returnType
+}
+
+// checkPositional reports named arguments passed to a callee whose
+// parameters have no names, like a builtin or a function value.
+func (c *Checker) checkPositional(name string, args []Expr) {
+	for _, arg := range args {
+		if named, ok := arg.(NamedArg); ok {
+			c.errorf("Cannot pass argument %s by name, %s takes positional arguments only", named.Name, name)
+			return
+		}
+	}
+}
+
+func (c *Checker) VisitMethodCall(call MethodCall) AvaVal {
+	c.checkConstCall("method " + call.Method)
+	recv := c.typeOf(call.Receiver)
+	types := c.argTypes(call.Args)
+
+	if isAny(recv) {
+		return typed(anyType)
+	}
+
+	// `x?.method()` is nil when x is nil
+	if call.Optional && recv.IsNullable {
+		recv.IsNullable = false
+		return typed(nullable(c.checkMethodCall(call, recv, types)))
+	}
+
+	return typed(c.checkMethodCall(call, c.nonNull(recv), types))
+}
+
+func (c *Checker) checkMethodCall(call MethodCall, recv TypeSpec, types []TypeSpec) TypeSpec {
+	if recv.IsNullableTarget {
+		recv = RefTo(c.throughRef(recv))
+	}
+
+	decl, ok := c.findMethod(recv, call.Method)
+	if !ok && c.hasField(recv, call.Method) {
+		// A field holding a function is called like a method, `button.on_click()`
+		typ := c.fieldType(FieldExpr{Expr: call.Receiver, Field: call.Method}, recv)
+		return c.callValue(call.Method, typ, call.Args, types)
+	} else if !ok {
+		c.errorf("Type %s has no method %s", recv, call.Method)
+		c.checkLambdaArgs(call.Args, types, nil, nil, nil)
+		return anyType
+	}
+
+	if !isMethod(decl) {
+		c.errorf("%s::%s has no self parameter and cannot be called on a value", recv, call.Method)
+		return anyType
+	}
+
+	c.dependOnMethod(recv, call.Method)
+
+	// Methods taking `&self` can be called on values and the other way around.
+	recv.IsRef = decl.Params[0].Type.IsRef
+
+	return c.checkCall(call.Method, decl, append([]Expr{call.Receiver}, call.Args...), append([]TypeSpec{recv}, types...))
+}
+
+func (c *Checker) hasField(typ TypeSpec, name string) bool {
+	decl, ok := c.structs[typ.Name]
+	if !ok || typ.Kind != NamedType {
+		return false
+	}
+
+	for _, field := range decl.Fields {
+		if field.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nullable makes typ able to hold nil. Void stays void.
+func nullable(typ TypeSpec) TypeSpec {
+	if !isVoid(typ) && !isAny(typ) {
+		typ.IsNullable = true
+	}
+
+	return typ
+}
+
+// findVariant resolves a qualified name like `Shape::Circle` to an enum variant.
+func (c *Checker) findVariant(name string) (EnumDecl, EnumVariant, bool) {
+	enumName, variantName, ok := strings.Cut(name, "::")
+	if !ok {
+		return EnumDecl{}, EnumVariant{}, false
+	}
+
+	enum, ok := c.enums[enumName]
+	if !ok {
+		return EnumDecl{}, EnumVariant{}, false
+	}
+
+	for _, variant := range enum.Variants {
+		if variant.Name == variantName {
+			return enum, variant, true
+		}
+	}
+
+	c.errorf("Enum %s has no variant %s", enumName, variantName)
+	return EnumDecl{}, EnumVariant{}, false
+}
+
+func (c *Checker) checkVariant(enum EnumDecl, variant EnumVariant, types []TypeSpec) AvaVal {
+	typ := NamedTypeSpec(enum.Name)
+	fields := variant.Fields
+
+	// Type arguments not used by the variant, like E of `Result::Ok(1)`, can
+	// be anything.
+	if len(enum.TypeParams) > 0 {
+		subst := make(map[string]TypeSpec)
+		for k := 0; k < len(fields) && k < len(types); k++ {
+			c.unify(fields[k], types[k], typeParamNames(enum.TypeParams), subst)
+		}
+
+		typ.Elems = Map(enum.TypeParams, func(t TypeParam) TypeSpec {
+			if s, ok := subst[t.Name]; ok {
+				return s
+			}
+			return anyType
+		})
+		fields = Map(fields, func(field TypeSpec) TypeSpec {
+			return substitute(field, enumSubst(enum, typ))
+		})
+	}
+
+	if len(types) != len(fields) {
+		c.errorf("Variant %s::%s expects %d values, but got %d", enum.Name, variant.Name, len(fields), len(types))
+	} else {
+		for k, field := range fields {
+			if !c.assignable(field, types[k]) {
+				c.errorf("Value %d of %s::%s must be %s, but got %s", k+1, enum.Name, variant.Name, field, types[k])
+			}
+		}
+	}
+
+	return typed(typ)
+}
+
+func (c *Checker) visitBuiltInCall(call FuncCall, types []TypeSpec) AvaVal {
+	m, ok := builtinFunc(call.Name)
+	if !ok {
+		c.errorf("Undefined function %s", call.Name)
+		return typed(anyType)
+	}
+
+	fn := m.Type()
+	params := make([]TypeSpec, fn.NumIn())
+	for k := range params {
+		params[k] = typeSpecFromGo(fn.In(k))
+	}
+
+	if fn.IsVariadic() {
+		variadic := params[len(params)-1].Elems[0]
+		params = params[:len(params)-1]
+
+		if len(types) < len(params) {
+			c.errorf("Function %s expects at least %d arguments, but got %d", call.Name, len(params), len(types))
+		}
+		for len(params) < len(types) {
+			params = append(params, variadic)
+		}
+	} else if len(types) != len(params) {
+		c.errorf("Function %s expects %d arguments, but got %d", call.Name, len(params), len(types))
+		params = params[:0]
+	}
+
+	for k := 0; k < len(types) && k < len(params); k++ {
+		if !c.assignable(params[k], types[k]) {
+			c.errorf("Argument %d of %s must be %s, but got %s", k+1, call.Name, params[k], types[k])
+		}
+	}
+
+	if fn.NumOut() == 0 {
+		return typed(voidType)
+	} else if fn.NumOut() == 2 {
+		// Builtins returning a Go error return a Result
+		return typed(resultType(typeSpecFromGo(fn.Out(0))))
+	}
+	return typed(typeSpecFromGo(fn.Out(0)))
+}
+
+// typeSpecFromGo maps the Go types used in AvaBuiltins signatures to Ava types.
+func typeSpecFromGo(typ reflect.Type) TypeSpec {
+	switch typ.Kind() {
+	case reflect.Int:
+		return NamedTypeSpec("i64")
+	case reflect.Float64:
+		return floatType
+	case reflect.String:
+		return strType
+	case reflect.Int32:
+		return charType
+	case reflect.Pointer:
+		return nullable(typeSpecFromGo(typ.Elem()))
+	case reflect.Bool:
+		return boolType
+	case reflect.Slice:
+		return TypeSpec{
+			Kind:  SliceType,
+			Elems: []TypeSpec{typeSpecFromGo(typ.Elem())},
+		}
+	}
+
+	return anyType
+}
+
+// checkMain checks the signature of main, which may take the arguments of the
+// program and return an exit code.
+func (c *Checker) checkMain() {
+	decl, ok := c.functions["main"]
+	if !ok {
+		return
+	}
+
+	argsType := TypeSpec{
+		Kind:  SliceType,
+		Elems: []TypeSpec{strType},
+	}
+	if len(decl.TypeParams) > 0 || len(decl.Params) > 1 || (len(decl.Params) == 1 && !c.assignable(argsType, decl.Params[0].Type)) {
+		c.errorf("Function main must take no parameters or `args: []str`")
+	}
+	if !isVoid(decl.ReturnType) && !isIntType(decl.ReturnType) {
+		c.errorf("Function main must return void or an integer exit code, but returns %s", decl.ReturnType)
+	}
+}
+
+func (c *Checker) VisitFuncDecl(decl FuncDecl) AvaVal {
+	c.checkFunction(decl.Name, decl)
+
+	return AvaVal{}
+}
+
+func (c *Checker) checkFunction(name string, decl FuncDecl) {
+	c.function = name
+	c.closureNils = make(map[string]bool)
+	c.constFun = decl.IsConst
+	c.returnType = decl.ReturnType
+	c.declareTypeParams(decl.TypeParams)
+	c.checkTypeSpec(decl.ReturnType)
+
+	c.checkParams(decl.Params)
+
+	c.environment.EnterBlock()
+	for _, param := range decl.Params {
+		c.environment.DeclareAssign(param.Name, checkedVar{
+			Type: param.Type,
+		})
+	}
+
+	c.Visit(decl.Body)
+
+	c.environment.ExitBlock()
+
+	if !isVoid(decl.ReturnType) && !terminates(decl.Body) {
+		c.errorf("Missing return at the end of function")
+	}
+
+	c.function = ""
+	c.constFun = false
+	c.returnType = TypeSpec{}
+	c.typeParams = nil
+}
+
+// checkParams checks the types and default values of the parameters of a
+// function. Defaults are evaluated where the function is declared, so they
+// cannot use the other parameters.
+func (c *Checker) checkParams(params []FuncParam) {
+	optional := false
+	for k, param := range params {
+		c.checkTypeSpec(param.Type)
+
+		if param.IsVariadic && k != len(params)-1 {
+			c.errorf("Variadic parameter %s must be the last parameter", param.Name)
+		}
+
+		if param.Default != nil {
+			optional = true
+			if typ := c.typeOfAs(param.Default, param.Type); !c.assignable(param.Type, typ) {
+				c.errorf("Default value of parameter %s must be %s, but got %s", param.Name, param.Type, typ)
+			}
+			if param.IsVariadic {
+				c.errorf("Variadic parameter %s cannot have a default value", param.Name)
+			}
+		} else if optional && !param.IsVariadic {
+			c.errorf("Parameter %s needs a default value, because it follows a parameter with one", param.Name)
+		}
+	}
+}
+
+func (c *Checker) VisitFuncLit(lit FuncLit) AvaVal {
+	return typed(c.checkFuncLit(lit, TypeSpec{}))
+}
+
+// isUntypedLambda reports lambdas whose type depends on where they are used,
+// because a parameter or the return type of a block body is left out.
+func isUntypedLambda(expr Expr) bool {
+	lit, ok := expr.(FuncLit)
+	if !ok {
+		return false
+	}
+
+	for _, param := range lit.Params {
+		if param.Type.Kind == NoType {
+			return true
+		}
+	}
+
+	return lit.IsLambda && !lit.IsExprBody && lit.ReturnType.Kind == NoType
+}
+
+// checkFuncLit checks an anonymous function and returns its type. Lambdas
+// take the types they leave out from expected, the function type where the
+// lambda is used, and expression lambdas return the type of their expression.
+func (c *Checker) checkFuncLit(lit FuncLit, expected TypeSpec) TypeSpec {
+	expected.IsNullable = false
+	if expected.Kind != FuncType || len(expected.Params()) != len(lit.Params) {
+		expected = TypeSpec{}
+	}
+
+	params := make([]TypeSpec, len(lit.Params))
+	for k, param := range lit.Params {
+		if param.Default != nil || param.IsVariadic {
+			c.errorf("Parameter %s of a function literal cannot have a default value or be variadic", param.Name)
+		}
+
+		params[k] = param.Type
+		if param.Type.Kind != NoType {
+			c.checkTypeSpec(param.Type)
+		} else if expected.Kind == FuncType {
+			params[k] = expected.Params()[k]
+		} else {
+			c.errorf("Cannot infer the type of parameter %s, declare it like `%s: T`", param.Name, param.Name)
+			params[k] = anyType
+		}
+	}
+
+	returnType := lit.ReturnType
+	if returnType.Kind != NoType {
+		c.checkTypeSpec(returnType)
+	} else if lit.IsLambda && expected.Kind == FuncType {
+		returnType = expected.Return()
+	}
+
+	outerReturnType := c.returnType
+	outerDepth := c.closureDepth
+	c.closureDepth = c.environment.Depth()
+	c.environment.EnterBlock()
+	for k, param := range lit.Params {
+		c.environment.DeclareAssign(param.Name, checkedVar{
+			Type: params[k],
+		})
+	}
+
+	if lit.IsExprBody {
+		c.returnType = TypeSpec{}
+		returnType = c.typeOf(lit.Body.Stmts[0].(ReturnStmt).Value)
+	} else {
+		c.returnType = returnType
+		c.Visit(lit.Body)
+
+		if !isVoid(returnType) && !terminates(lit.Body) {
+			c.errorf("Missing return at the end of function")
+		}
+	}
+
+	c.environment.ExitBlock()
+	c.returnType = outerReturnType
+	c.closureDepth = outerDepth
+
+	return FuncTypeSpec(params, returnType)
+}
+
+// terminates reports whether a block always ends with a return statement.
+func terminates(block Block) bool {
+	if len(block.Stmts) == 0 {
+		return false
+	}
+
+	switch last := block.Stmts[len(block.Stmts)-1].(type) {
+	case ReturnStmt:
+		return true
+	case ExprStmt:
+		return terminatesExpr(last.Expr)
+	case IfStmt:
+		return last.HasElse && terminates(last.ThenBody) && terminates(last.ElseBody)
+	}
+
+	return false
+}
+
+// terminatesExpr reports whether an expression never finishes, like a call of
+// Panic or std::os::exit, or a match whose arms all terminate. A match is
+// exhaustive, or the checker reports it.
+func terminatesExpr(expr Node) bool {
+	switch expr := expr.(type) {
+	case Block:
+		return terminates(expr)
+	case FuncCall:
+		return expr.Name == "Panic" || expr.Name == "std::os::exit"
+	case MatchExpr:
+		for _, arm := range expr.Arms {
+			if !terminatesExpr(arm.Body) {
+				return false
+			}
+		}
+		return len(expr.Arms) > 0
+	}
+
+	return false
+}
+
+// declare checks a variable declaration and returns the type of the variable.
+func (c *Checker) declare(name string, declared TypeSpec, init Expr) TypeSpec {
+	if init == nil {
+		if declared.Kind == NoType {
+			c.errorf("Variable %s needs a type or an initial value", name)
+			return anyType
+		}
+
+		c.checkTypeSpec(declared)
+		return declared
+	}
+
+	// The elements of an array literal only need to fit the declared element
+	// type, so `[&a, &b]` can hold different types behind a trait object.
+	if lit, ok := init.(ArrayLit); ok && declared.Kind == SliceType && len(lit.Elems) > 0 {
+		c.checkTypeSpec(declared)
+		for k, elem := range lit.Elems {
+			if typ := c.typeOfAs(elem, declared.Elems[0]); !c.assignable(declared.Elems[0], typ) {
+				c.errorf("Array element %d must be %s, but got %s", k+1, declared.Elems[0], typ)
+			}
+		}
+		return declared
+	}
+
+	typ := c.typeOfAs(init, declared)
+	if isVoid(typ) {
+		c.errorf("Cannot use void value to initialize %s", name)
+		typ = anyType
+	}
+
+	if declared.Kind == NoType {
+		if isNil(typ) {
+			c.errorf("Cannot infer the type of %s from nil, declare it like `%s: T?`", name, name)
+			return anyType
+		}
+		return concrete(typ)
+	}
+
+	c.checkTypeSpec(declared)
+	if !c.assignable(declared, typ) {
+		c.errorf("Variable %s declared with type %s, but got expression with type %s", name, declared, typ)
+	}
+
+	return declared
+}
+
+func (c *Checker) VisitConstDecl(decl ConstDecl) AvaVal {
+	typ := c.declare(decl.Name, decl.Type, decl.Init)
+
+	deps := make(map[string]*AvaVal)
+	c.checkConstExpr(decl.Name, decl.Init, deps)
+	c.consts = append(c.consts, pendingConst{
+		decl:     decl,
+		typ:      typ,
+		function: c.function,
+		deps:     deps,
+	})
+
+	c.environment.DeclareAssign(decl.Name, checkedVar{
+		Type:    typ,
+		IsConst: true,
+		Value:   decl.Value,
+	})
+
+	return AvaVal{}
+}
+
+func (c *Checker) VisitVarDecl(decl VarDecl) AvaVal {
+	typ := c.declare(decl.Name, decl.Type, decl.Init)
+
+	c.environment.DeclareAssign(decl.Name, checkedVar{
+		Type: typ,
+	})
+
+	return AvaVal{}
+}
+
+func (c *Checker) VisitTupleVarDecl(decl TupleVarDecl) AvaVal {
+	typ := c.typeOf(decl.Init)
+
+	if decl.Type.Kind != NoType {
+		c.checkTypeSpec(decl.Type)
+		if !c.assignable(decl.Type, typ) {
+			c.errorf("Variables (%s) declared with type %s, but got expression with type %s", decl.Names, decl.Type, typ)
+		}
+		typ = decl.Type
+	}
+
+	elems := make([]TypeSpec, len(decl.Names))
+	for k := range elems {
+		elems[k] = anyType
+	}
+
+	if typ.Kind == TupleType && len(typ.Elems) == len(decl.Names) {
+		elems = typ.Elems
+	} else if typ.Kind == TupleType {
+		c.errorf("Cannot destructure tuple of %d elements into %d variables", len(typ.Elems), len(decl.Names))
+	} else if !isAny(typ) {
+		c.errorf("Cannot destructure value of type %s", typ)
+	}
+
+	for k, name := range decl.Names {
+		c.environment.DeclareAssign(name, checkedVar{
+			Type: concrete(elems[k]),
+		})
+	}
+
+	return AvaVal{}
+}
+
+func (c *Checker) VisitVariable(variable Variable) AvaVal {
+	if enum, variant, ok := c.findVariant(variable.Name); ok {
+		return c.checkVariant(enum, variant, []TypeSpec{})
+	}
+
+	v, ok := c.lookup(variable.Name)
+	if ok {
+		if c.constFun && !v.IsConst && c.environment.IsGlobal(variable.Name) {
+			c.errorf("Const function cannot use global variable %s", variable.Name)
+		}
+		return typed(v.Type)
+	}
+
+	// Functions can be used as values
+	decl, ok := c.functions[variable.Name]
+	if !ok {
+		decl, ok = c.findStaticMethod(variable.Name)
+	}
+	if ok {
+		if len(decl.TypeParams) > 0 {
+			c.errorf("Generic function %s cannot be used as a value", variable.Name)
+			return typed(anyType)
+		}
+		// A function type has no variadic parameters, so calls of the value
+		// could not collect their arguments.
+		for _, param := range decl.Params {
+			if param.IsVariadic {
+				c.errorf("Variadic function %s cannot be used as a value", variable.Name)
+				return typed(anyType)
+			}
+		}
+		c.dependOn(variable.Name)
+		return typed(decl.Type())
+	}
+
+	if strings.Contains(variable.Name, "::") {
+		c.errorf("Undefined name %s", variable.Name)
+	} else {
+		c.errorf("Undefined variable %s", variable.Name)
+	}
+	return typed(anyType)
+}
+
+func (c *Checker) VisitIndexExpr(expr IndexExpr) AvaVal {
+	// Elements are read through references
+	typ := c.throughRef(c.typeOf(expr.Expr))
+	index := c.typeOf(expr.Index)
+
+	switch {
+	case isAny(typ):
+		return typed(anyType)
+	case typ.Kind == SliceType:
+		if index.Kind == NamedType && index.Name == rangeType.Name {
+			return typed(typ)
+		}
+
+		if !isInteger(index) {
+			c.errorf("Array index must be an integer, but got %s", index)
+		}
+		return typed(typ.Elems[0])
+	case typ.Kind == MapType:
+		if !c.assignable(typ.Elems[0], index) {
+			c.errorf("Map key must be %s, but got %s", typ.Elems[0], index)
+		}
+		return typed(typ.Elems[1])
+	case typ.Kind == NamedType && typ.Name == strType.Name:
+		// Strings are indexed by rune
+		if index.Kind == NamedType && index.Name == rangeType.Name {
+			return typed(strType)
+		}
+
+		if !isInteger(index) {
+			c.errorf("String index must be an integer, but got %s", index)
+		}
+		return typed(charType)
+	}
+
+	c.errorf("Cannot index value of type %s", typ)
+	return typed(anyType)
+}
+
+func (c *Checker) VisitFieldExpr(expr FieldExpr) AvaVal {
+	typ := c.typeOf(expr.Expr)
+
+	// `x?.field` is nil when x is nil
+	if expr.Optional && typ.IsNullable {
+		typ.IsNullable = false
+		return typed(nullable(c.fieldType(expr, typ)))
+	}
+
+	return typed(c.fieldType(expr, c.nonNull(typ)))
+}
+
+func (c *Checker) fieldType(expr FieldExpr, typ TypeSpec) TypeSpec {
+	// Fields are read through references
+	typ = c.throughRef(typ)
+
+	if isAny(typ) {
+		return anyType
+	}
+
+	if typ.Kind == TupleType {
+		k, err := strconv.Atoi(expr.Field)
+		if err != nil || k < 0 || k >= len(typ.Elems) {
+			c.errorf("Tuple %s has no field %s", typ, expr.Field)
+			return anyType
+		}
+
+		return typ.Elems[k]
+	}
+
+	if decl, ok := c.structs[typ.Name]; ok && typ.Kind == NamedType {
+		for _, field := range decl.Fields {
+			if field.Name == expr.Field {
+				return substitute(field.Type, structSubst(decl, typ))
+			}
+		}
+
+		c.errorf("Struct %s has no field %s", typ.Name, expr.Field)
+		return anyType
+	}
+
+	c.errorf("Value of type %s has no field %s", typ, expr.Field)
+	return anyType
+}
+
+func (c *Checker) VisitCoalesceExpr(expr CoalesceExpr) AvaVal {
+	left := c.typeOf(expr.Left)
+	right := c.typeOf(expr.Right)
+
+	if isAny(left) || isNil(left) {
+		return typed(right)
+	}
+
+	if !left.IsNullable {
+		c.errorf("Left side of ?? has type %s, which is never nil", left)
+		return typed(left)
+	}
+
+	left.IsNullable = false
+	if !c.assignable(nullable(left), right) {
+		c.errorf("Cannot use value of type %s as a default for %s", right, left)
+	}
+
+	// `a ?? b` is only nil if b can be nil
+	if right.IsNullable || isNil(right) {
+		return typed(nullable(left))
+	}
+	return typed(left)
+}
+
+func (c *Checker) VisitTryExpr(expr TryExpr) AvaVal {
+	typ := c.throughRef(c.typeOf(expr.Expr))
+	if isAny(typ) {
+		return typed(anyType)
+	}
+
+	if !isResult(typ) {
+		c.errorf("Operator ? cannot be applied to %s, it needs a Result", typ)
+		return typed(anyType)
+	}
+
+	if !isResult(c.returnType) {
+		c.errorf("Operator ? can only be used in a function returning a Result, not %s", c.returnType)
+	} else if !c.assignable(c.returnType.Elems[1], typ.Elems[1]) {
+		c.errorf("Operator ? cannot return error of type %s from function returning %s", typ.Elems[1], c.returnType)
+	}
+
+	return typed(typ.Elems[0])
+}
+
+func (c *Checker) VisitRecoverExpr(expr RecoverExpr) AvaVal {
+	return typed(resultType(c.typeOf(expr.Call)))
+}
+
+func isResult(typ TypeSpec) bool {
+	return typ.Kind == NamedType && typ.Name == "Result" && !typ.IsNullable && len(typ.Elems) == 2
+}
+
+// resultType is `T!Error`, the result of a builtin that can fail.
+func resultType(typ TypeSpec) TypeSpec {
+	return TypeSpec{
+		Kind:  NamedType,
+		Name:  "Result",
+		Elems: []TypeSpec{typ, errorType},
+	}
+}
+
+func (c *Checker) VisitRefExpr(expr RefExpr) AvaVal {
+	typ := c.typeOf(expr.Expr)
+	if isAny(typ) {
+		return typed(anyType)
+	}
+
+	if typ.IsRef {
+		c.errorf("Cannot take a reference to a reference of type %s", typ)
+	}
+
+	// References can be written through, so constants cannot be referenced.
+	if root, ok := placeRoot(expr.Expr); ok {
+		if v, found := c.environment.Lookup(root); found && v.IsConst {
+			c.errorf("Cannot take a mutable reference to constant %s", root)
+		}
+	}
+
+	return typed(RefTo(typ))
+}
+
+func (c *Checker) VisitDerefExpr(expr DerefExpr) AvaVal {
+	typ := c.nonNull(c.typeOf(expr.Expr))
+	if isAny(typ) {
+		return typed(anyType)
+	}
+
+	if !typ.IsRef {
+		c.errorf("Cannot dereference value of type %s", typ)
+		return typed(anyType)
+	}
+
+	return typed(Referent(typ))
+}
+
+func (c *Checker) VisitMatchExpr(expr MatchExpr) AvaVal {
+	subject := c.typeOf(expr.Subject)
+	// Matching looks through references
+	subject = Referent(subject)
+
+	result := TypeSpec{}
+	for _, arm := range expr.Arms {
+		c.environment.EnterBlock()
+
+		c.checkPattern(arm.Pattern, subject)
+		if arm.Guard != nil {
+			c.checkCondition(arm.Guard)
+		}
+
+		typ := voidType
+		if body, ok := arm.Body.(Expr); ok {
+			typ = c.typeOf(body)
+		} else {
+			c.Visit(arm.Body)
+		}
+
+		c.environment.ExitBlock()
+
+		if result.Kind == NoType || isAny(result) {
+			result = typ
+		} else if !c.assignable(result, typ) {
+			c.errorf("Match arms have different types %s and %s", result, typ)
+		}
+	}
+
+	if !isAny(subject) {
+		c.checkExhaustive(expr.Arms, subject)
+	}
+
+	if result.Kind == NoType {
+		return typed(voidType)
+	}
+	return typed(result)
+}
+
+// checkPattern checks that a pattern can match values of the given type and
+// declares the variables it binds.
+func (c *Checker) checkPattern(pattern Pattern, typ TypeSpec) {
+	switch p := pattern.(type) {
+	case BindingPattern:
+		c.environment.DeclareAssign(p.Name, checkedVar{
+			Type: concrete(typ),
+		})
+	case LiteralPattern:
+		lit := c.typeOf(p.Value)
+		if !c.assignable(typ, lit) {
+			c.errorf("Pattern of type %s cannot match value of type %s", lit, typ)
+		}
+	case TuplePattern:
+		elems := make([]TypeSpec, len(p.Elems))
+		for k := range elems {
+			elems[k] = anyType
+		}
+
+		if typ.Kind == TupleType && len(typ.Elems) == len(p.Elems) {
+			elems = typ.Elems
+		} else if !isAny(typ) {
+			c.errorf("Tuple pattern of %d elements cannot match value of type %s", len(p.Elems), typ)
+		}
+
+		for k, elem := range p.Elems {
+			c.checkPattern(elem, elems[k])
+		}
+	case VariantPattern:
+		fields := make([]TypeSpec, len(p.Fields))
+		for k := range fields {
+			fields[k] = anyType
+		}
+
+		if enum, variant, ok := c.findVariant(p.Enum + "::" + p.Variant); !ok {
+			if _, isEnum := c.enums[p.Enum]; !isEnum {
+				c.errorf("Undefined enum %s", p.Enum)
+			}
+		} else if !isAny(typ) && !(typ.Kind == NamedType && typ.Name == enum.Name && !typ.IsRef) {
+			c.errorf("Pattern %s::%s cannot match value of type %s", p.Enum, p.Variant, typ)
+		} else if len(variant.Fields) != len(p.Fields) {
+			c.errorf("Variant %s::%s has %d values, but the pattern has %d", p.Enum, p.Variant, len(variant.Fields), len(p.Fields))
+		} else {
+			fields = Map(variant.Fields, func(field TypeSpec) TypeSpec {
+				return substitute(field, enumSubst(enum, typ))
+			})
+		}
+
+		for k, field := range p.Fields {
+			c.checkPattern(field, fields[k])
+		}
+	}
+}
+
+// patternCtor is one of the shapes a value of some type can have, e.g. a
+// variant of an enum or `true` for bool.
+type patternCtor struct {
+	// Label names the constructor in diagnostics, it is empty for tuples.
+	Label  string
+	Name   string
+	Fields []TypeSpec
+}
+
+// constructors lists all shapes of a type, or returns nil when the type has
+// too many values to list, like integers or strings.
+func (c *Checker) constructors(typ TypeSpec) []patternCtor {
+	if typ.Kind == TupleType {
+		return []patternCtor{{Fields: typ.Elems}}
+	}
+
+	if typ.Kind != NamedType || typ.IsRef || typ.IsNullable {
+		return nil
+	}
+
+	if typ.Name == boolType.Name {
+		return []patternCtor{
+			{Label: "true", Name: "true"},
+			{Label: "false", Name: "false"},
+		}
+	}
+
+	if enum, ok := c.enums[typ.Name]; ok {
+		return Map(enum.Variants, func(v EnumVariant) patternCtor {
+			return patternCtor{
+				Label: enum.Name + "::" + v.Name,
+				Name:  v.Name,
+				Fields: Map(v.Fields, func(field TypeSpec) TypeSpec {
+					return substitute(field, enumSubst(enum, typ))
+				}),
+			}
+		})
+	}
+
+	return nil
+}
+
+// specialize keeps the rows whose first pattern can match the constructor and
+// replaces that pattern with patterns for the constructor's fields.
+func (c *Checker) specialize(rows [][]Pattern, ctor patternCtor) [][]Pattern {
+	res := make([][]Pattern, 0)
+
+	for _, row := range rows {
+		var fields []Pattern
+
+		switch p := row[0].(type) {
+		case WildcardPattern, BindingPattern:
+			fields = make([]Pattern, len(ctor.Fields))
+			for k := range fields {
+				fields[k] = WildcardPattern{}
+			}
+		case TuplePattern:
+			fields = p.Elems
+		case VariantPattern:
+			if p.Variant == ctor.Name {
+				fields = p.Fields
+			}
+		case LiteralPattern:
+			if lit, ok := p.Value.(BoolLit); ok && fmt.Sprint(lit.Value) == ctor.Name {
+				fields = []Pattern{}
+			}
+		}
+
+		if fields == nil || len(fields) != len(ctor.Fields) {
+			continue
+		}
+
+		specialized := append(append([]Pattern{}, fields...), row[1:]...)
+		res = append(res, specialized)
+	}
+
+	return res
+}
+
+// exhaustive reports whether every combination of values of the given types
+// is matched by at least one row of patterns.
+func (c *Checker) exhaustive(rows [][]Pattern, types []TypeSpec) bool {
+	if len(rows) == 0 {
+		return false
+	} else if len(types) == 0 {
+		return true
+	}
+
+	// When no row looks into the first value, only the rows that match any
+	// value there are left. Listing the constructors would not end for a
+	// recursive enum like `enum List { Nil, Cons(i32, List) }`.
+	refuted := false
+	for _, row := range rows {
+		refuted = refuted || !isIrrefutable(row[0])
+	}
+
+	ctors := c.constructors(types[0])
+	if ctors == nil || !refuted {
+		rest := make([][]Pattern, 0)
+		for _, row := range rows {
+			if isIrrefutable(row[0]) {
+				rest = append(rest, row[1:])
+			}
+		}
+
+		return c.exhaustive(rest, types[1:])
+	}
+
+	for _, ctor := range ctors {
+		fields := append(append([]TypeSpec{}, ctor.Fields...), types[1:]...)
+		if !c.exhaustive(c.specialize(rows, ctor), fields) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *Checker) checkExhaustive(arms []MatchArm, typ TypeSpec) {
+	// Guarded arms can fail, so they do not count towards exhaustiveness.
+	rows := make([][]Pattern, 0)
+	for _, arm := range arms {
+		if arm.Guard == nil {
+			rows = append(rows, []Pattern{arm.Pattern})
+		}
+	}
+
+	if c.exhaustive(rows, []TypeSpec{typ}) {
+		return
+	}
+
+	missing := make([]string, 0)
+	for _, ctor := range c.constructors(typ) {
+		if len(ctor.Label) > 0 && !c.exhaustive(c.specialize(rows, ctor), ctor.Fields) {
+			missing = append(missing, ctor.Label)
+		}
+	}
+
+	if len(missing) > 0 {
+		c.errorf("Non-exhaustive match on %s, missing %s", typ, strings.Join(missing, ", "))
+	} else {
+		c.errorf("Non-exhaustive match on %s, add a `_` arm", typ)
+	}
+}
+
+func (c *Checker) VisitRangeExpr(expr RangeExpr) AvaVal {
+	start := c.typeOf(expr.Start)
+	end := c.typeOf(expr.End)
+
+	if !isInteger(start) || !isInteger(end) {
+		c.errorf("Range bounds must be integers, but got %s and %s", start, end)
+	}
+
+	return typed(rangeType)
+}
+
+func (c *Checker) VisitIntLit(lit IntLit) AvaVal {
+	return typed(c.intLitType(lit, false))
+}
+
+// intLitType returns the type of an integer literal and reports a literal
+// that overflows it. Without a suffix a literal is an i32, or an i64 when it
+// does not fit.
+func (c *Checker) intLitType(lit IntLit, negated bool) TypeSpec {
+	typ := lit.Type
+	if typ == "" {
+		typ = intType.Name
+		if !fitsInt(lit, typ, negated) {
+			typ = "i64"
+		}
+	}
+
+	if !fitsInt(lit, typ, negated) {
+		c.overflow(lit, negated, NamedTypeSpec(typ))
+	}
+
+	spec := NamedTypeSpec(typ)
+	spec.IsLiteral = lit.Type == ""
+	return spec
+}
+
+// concrete returns typ without the marks of integer literals, for the type
+// of a variable. `var n = 5;` declares an i32, which does not fit in a u8.
+func concrete(typ TypeSpec) TypeSpec {
+	typ.IsLiteral = false
+	if len(typ.Elems) > 0 {
+		typ.Elems = Map(typ.Elems, concrete)
+	}
+
+	return typ
+}
+
+// fitsInt reports whether a literal fits in the integer type typ. A negated
+// literal may be one larger than the largest value, so `-128i8` fits in i8.
+func fitsInt(lit IntLit, typ string, negated bool) bool {
+	// Literals are never negative, the values above the range of int wrap around.
+	value := uint64(lit.Value)
+	if negated && strings.HasPrefix(typ, "u") {
+		return value == 0
+	} else if negated {
+		return value <= intTypeMax[typ]+1
+	}
+
+	return value <= intTypeMax[typ]
+}
+
+// overflow reports an integer literal that does not fit in typ. A u64 holds
+// its value like an i64 does, so it only goes up to the i64 maximum.
+func (c *Checker) overflow(lit IntLit, negated bool, typ TypeSpec) {
+	if c.underlying(typ).Name == "u64" && !negated {
+		c.errorf("Integer literal %s overflows %s, whose values only go up to the i64 maximum %d", intLitText(lit, negated), typ, intTypeMax["u64"])
+		return
+	}
+
+	c.errorf("Integer literal %s overflows %s", intLitText(lit, negated), typ)
+}
+
+func intLitText(lit IntLit, negated bool) string {
+	text := strconv.FormatUint(uint64(lit.Value), 10) + lit.Type
+	if negated {
+		return "-" + text
+	}
+
+	return text
+}
+
+// checkIntRange reports an integer literal without a suffix that does not fit
+// in the integer type it is used as, like `var a: u8 = 300;`.
+func (c *Checker) checkIntRange(expected TypeSpec, expr Expr) {
+	expected.IsNullable = false
+	lit, negated, ok := untypedIntLit(expr)
+	if typ := c.underlying(expected); ok && isIntType(typ) && !fitsInt(lit, typ.Name, negated) {
+		c.overflow(lit, negated, expected)
+	}
+}
+
+// untypedIntLit returns the integer literal without a suffix that expr is,
+// looking through parentheses and negations like `-(5)`.
+func untypedIntLit(expr Expr) (lit IntLit, negated bool, ok bool) {
+	for {
+		if paren, isParen := expr.(ParenExpr); isParen {
+			expr = paren.Expr
+		} else if call, isCall := expr.(FuncCall); isCall && isNegation(call) {
+			expr, negated = call.Args[0], !negated
+		} else {
+			break
+		}
+	}
+
+	lit, ok = expr.(IntLit)
+	return lit, negated, ok && lit.Type == ""
+}
+
+func isNegation(call FuncCall) bool {
+	return call.IsArithmetic && call.Name == "-" && len(call.Args) == 1
+}
+
+func (c *Checker) VisitFloatLit(lit FloatLit) AvaVal {
+	if lit.Type != "" {
+		return typed(NamedTypeSpec(lit.Type))
+	}
+
+	return typed(floatType)
+}
+
+func (c *Checker) VisitBoolLit(_ BoolLit) AvaVal {
+	return typed(boolType)
+}
+
+func (c *Checker) VisitStrLit(_ StrLit) AvaVal {
+	return typed(strType)
+}
+
+func (c *Checker) VisitCharLit(_ CharLit) AvaVal {
+	return typed(charType)
+}
+
+func (c *Checker) VisitInterpStr(s InterpStr) AvaVal {
+	for _, part := range s.Parts {
+		if typ := c.typeOf(part); isVoid(typ) {
+			c.errorf("Cannot interpolate a void value at %s", s.Pos)
+		}
+	}
+
+	return typed(strType)
+}
+
+func (c *Checker) VisitNilLit(_ NilLit) AvaVal {
+	return typed(nilType)
+}
+
+func (c *Checker) VisitArrayLit(lit ArrayLit) AvaVal {
+	if len(lit.Elems) == 0 {
+		return typed(TypeSpec{
+			Kind:  SliceType,
+			Elems: []TypeSpec{anyType},
+		})
+	}
+
+	types := Map(lit.Elems, c.typeOf)
+	for k, typ := range types[1:] {
+		if !c.assignable(types[0], typ) {
+			c.errorf("Array element %d must be %s, but got %s", k+2, types[0], typ)
+		}
+	}
+
+	return typed(TypeSpec{
+		Kind:  SliceType,
+		Elems: []TypeSpec{types[0]},
+	})
+}
+
+func (c *Checker) VisitMapLit(lit MapLit) AvaVal {
+	c.checkTypeSpec(lit.Type)
+
+	for k, key := range lit.Keys {
+		keyType := c.typeOf(key)
+		if !c.assignable(lit.Type.Elems[0], keyType) {
+			c.errorf("Map key must be %s, but got %s", lit.Type.Elems[0], keyType)
+		}
+
+		valueType := c.typeOf(lit.Values[k])
+		if !c.assignable(lit.Type.Elems[1], valueType) {
+			c.errorf("Map value must be %s, but got %s", lit.Type.Elems[1], valueType)
+		}
+	}
+
+	return typed(lit.Type)
+}
+
+func (c *Checker) VisitStructLit(lit StructLit) AvaVal {
+	return typed(c.checkStructLit(lit, TypeSpec{}))
+}
+
+// checkStructLit checks a struct literal used where a value of type expected
+// goes. The type arguments of a generic struct are taken from expected, so
+// `var b: Box<str> = Box { items: [] };` needs no element to infer T.
+func (c *Checker) checkStructLit(lit StructLit, expected TypeSpec) TypeSpec {
+	decl, ok := c.structs[lit.Name]
+
+	known := make(map[string]TypeSpec)
+	if expected.Name == decl.Name && len(expected.Elems) == len(decl.TypeParams) {
+		for k, param := range decl.TypeParams {
+			known[param.Name] = expected.Elems[k]
+		}
+	}
+
+	types := Map(lit.Fields, func(f StructLitField) TypeSpec {
+		// Lambdas take their types from the field, unless the field type
+		// depends on type arguments that are not known yet.
+		expected := TypeSpec{}
+		for _, field := range decl.Fields {
+			if field.Name == f.Name && len(decl.TypeParams) == len(known) {
+				expected = substitute(field.Type, known)
+			}
+		}
+
+		return c.typeOfAs(f.Value, expected)
+	})
+
+	if !ok {
+		c.errorf("Undefined struct %s", lit.Name)
+		return anyType
+	}
+
+	// params, args and fields line up for the fields the struct declares
+	params := make([]TypeSpec, 0)
+	args := make([]TypeSpec, 0)
+	fields := make([]string, 0)
+	names := make([]string, 0)
+	for k, f := range lit.Fields {
+		if contains(names, f.Name) {
+			c.errorf("Duplicate field %s in literal of struct %s", f.Name, decl.Name)
+			continue
+		}
+		names = append(names, f.Name)
+
+		found := false
+		for _, field := range decl.Fields {
+			if field.Name == f.Name {
+				params = append(params, field.Type)
+				args = append(args, types[k])
+				fields = append(fields, f.Name)
+				found = true
+			}
+		}
+
+		if !found {
+			c.errorf("Struct %s has no field %s", decl.Name, f.Name)
+		}
+	}
+
+	for _, field := range decl.Fields {
+		if !contains(names, field.Name) {
+			c.errorf("Missing field %s in literal of struct %s", field.Name, decl.Name)
+		}
+	}
+
+	typ := NamedTypeSpec(decl.Name)
+	if len(decl.TypeParams) > 0 {
+		subst := known
+		if len(known) == 0 {
+			subst = c.inferTypeArgs(decl.Name, decl.TypeParams, params, args)
+		}
+		params = Map(params, func(p TypeSpec) TypeSpec {
+			return substitute(p, subst)
+		})
+		typ.Elems = Map(decl.TypeParams, func(t TypeParam) TypeSpec {
+			return subst[t.Name]
+		})
+	}
+
+	for k, param := range params {
+		if !c.assignable(param, args[k]) {
+			c.errorf("Field %s of struct %s must be %s, but got %s", fields[k], decl.Name, param, args[k])
+		}
+	}
+
+	return typ
+}
+
+func (c *Checker) VisitTupleLit(lit TupleLit) AvaVal {
+	return typed(TypeSpec{
+		Kind:  TupleType,
+		Elems: Map(lit.Elems, c.typeOf),
+	})
+}
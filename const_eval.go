@@ -0,0 +1,200 @@
+package main
+
+import "sort"
+
+// pendingConst is a const whose initializer is evaluated once the whole
+// program has been checked.
+type pendingConst struct {
+	decl     ConstDecl
+	typ      TypeSpec
+	function string
+	// deps holds the values of the consts the initializer uses.
+	deps map[string]*AvaVal
+}
+
+// checkConstExpr reports the parts of a const initializer that can only be
+// known at runtime and collects the consts it depends on.
+func (c *Checker) checkConstExpr(name string, expr Expr, deps map[string]*AvaVal) {
+	check := func(expr Expr) {
+		c.checkConstExpr(name, expr, deps)
+	}
+
+	switch e := expr.(type) {
+	case IntLit, FloatLit, StrLit, CharLit, BoolLit, NilLit:
+	case ParenExpr:
+		check(e.Expr)
+	case NamedArg:
+		check(e.Value)
+	case InterpStr:
+		for _, elem := range e.Parts {
+			check(elem)
+		}
+	case ArrayLit:
+		for _, elem := range e.Elems {
+			check(elem)
+		}
+	case TupleLit:
+		for _, elem := range e.Elems {
+			check(elem)
+		}
+	case StructLit:
+		for _, field := range e.Fields {
+			check(field.Value)
+		}
+	case FieldExpr:
+		check(e.Expr)
+	case IndexExpr:
+		check(e.Expr)
+		check(e.Index)
+	case Variable:
+		if v, ok := c.environment.Lookup(e.Name); ok {
+			if !v.IsConst || v.Value == nil {
+				c.errorf("Const %s depends on runtime value %s", name, e.Name)
+			}
+			deps[e.Name] = v.Value
+		} else if _, _, ok := c.findVariant(e.Name); !ok {
+			c.errorf("Const %s depends on runtime value %s", name, e.Name)
+		}
+	case FuncCall:
+		if !e.IsArithmetic && !e.IsComparison && !c.isConstCallee(e.Name) {
+			c.errorf("Const %s calls %s, which is not a const fun", name, e.Name)
+		}
+		for _, arg := range e.Args {
+			check(arg)
+		}
+	default:
+		c.errorf("Const %s must be known at compile time, it can only use literals, operators, consts and const functions", name)
+	}
+}
+
+// isConstCallee reports whether a call can run at compile time, which is the
+// case for const functions, enum variants and conversions.
+func (c *Checker) isConstCallee(name string) bool {
+	if _, _, ok := c.findVariant(name); ok {
+		return true
+	} else if _, ok := c.conversion(name); ok {
+		return true
+	}
+
+	decl, ok := c.functions[name]
+	return ok && decl.IsConst
+}
+
+// checkConstCall reports a call in a const fun that cannot run at compile time.
+func (c *Checker) checkConstCall(callee string) {
+	if c.constFun {
+		c.errorf("Const function can only call const functions, but %s is not one", callee)
+	}
+}
+
+// foldConsts evaluates the initializers of all consts and stores the results
+// in their declarations, so the interpreter does not run them again.
+func (c *Checker) foldConsts() {
+	i := newConstInterp(c.tree)
+
+	// Global consts are evaluated in the order globals are initialized in,
+	// as they may use each other through const functions.
+	order := make(map[string]int)
+	for k, glbl := range c.initOrder {
+		if name, ok := globalName(glbl); ok {
+			order[name] = k
+		}
+	}
+	rank := func(pending pendingConst) int {
+		if pending.decl.IsGlobal {
+			return order[pending.decl.Name]
+		}
+		return len(order)
+	}
+	sort.SliceStable(c.consts, func(a, b int) bool {
+		return rank(c.consts[a]) < rank(c.consts[b])
+	})
+
+	for _, pending := range c.consts {
+		val, ok := c.evalConst(i, pending)
+		if !ok {
+			continue
+		}
+
+		// A folded value like `255 + 1` must fit in the type, like a literal
+		if n, isInt := val.Value.(int); isInt && !c.fitsConst(n, pending.typ) {
+			c.function = pending.function
+			c.errorf("Const %s evaluates to %d, which overflows %s", pending.decl.Name, n, pending.typ)
+			c.function = ""
+			continue
+		}
+
+		*pending.decl.Value = val
+		if pending.decl.IsGlobal {
+			i.environment.DeclareAssign(pending.decl.Name, &AvaVar{
+				Type:    val.Type,
+				Value:   val,
+				IsConst: true,
+			})
+		}
+	}
+}
+
+// fitsConst reports whether the value of a const fits in its type.
+func (c *Checker) fitsConst(n int, typ TypeSpec) bool {
+	typ = c.underlying(typ)
+	if !isIntType(typ) {
+		return true
+	} else if n < 0 {
+		return fitsInt(IntLit{Value: -n}, typ.Name, true)
+	}
+
+	return fitsInt(IntLit{Value: n}, typ.Name, false)
+}
+
+func (c *Checker) evalConst(i *Interp, pending pendingConst) (val AvaVal, ok bool) {
+	global := i.environment
+	i.environment = global.Capture()
+	i.environment.EnterBlock()
+
+	defer func() {
+		i.environment = global
+		i.callStack = nil
+
+		if r := recover(); r != nil {
+			err, isRuntime := r.(RuntimeError)
+			if !isRuntime {
+				panic(r)
+			}
+
+			c.function = pending.function
+			c.errorf("Evaluating const %s failed: %s", pending.decl.Name, err.Message)
+			c.function = ""
+			ok = false
+		}
+	}()
+
+	for name, dep := range pending.deps {
+		i.environment.DeclareAssign(name, &AvaVar{
+			Type:    dep.Type,
+			Value:   *dep,
+			IsConst: true,
+		})
+	}
+
+	return i.Visit(pending.decl.Init), true
+}
+
+// newConstInterp returns an interpreter that knows the types and const
+// functions of a program, which is all a const initializer can use.
+func newConstInterp(tree ProgStmt) *Interp {
+	i := newInterp(tree)
+
+	for _, glbl := range tree.Glbls {
+		switch decl := glbl.(type) {
+		case StructDecl, EnumDecl, TypeDecl:
+			i.Visit(decl)
+		case FuncDecl:
+			if decl.IsConst {
+				i.Visit(decl)
+			}
+		}
+	}
+
+	return i
+}
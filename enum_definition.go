@@ -0,0 +1,16 @@
+package main
+
+type EnumDefinition struct {
+	Name     string
+	Variants []EnumVariant
+}
+
+func (e EnumDefinition) Variant(name string) (EnumVariant, bool) {
+	for _, variant := range e.Variants {
+		if variant.Name == name {
+			return variant, true
+		}
+	}
+
+	return EnumVariant{}, false
+}
@@ -1,10 +1,5 @@
 package main
 
-import (
-	"fmt"
-	"os"
-)
-
 type Environment[T any] struct {
 	envs []map[string]T
 }
@@ -28,6 +23,23 @@ func (e *Environment[T]) ExitBlock() {
 	e.envs = e.envs[:len(e.envs)-1]
 }
 
+// Capture returns an environment sharing the scopes of e, so variables
+// declared or changed in one are seen by the other. Blocks entered in the
+// capture do not affect e.
+func (e *Environment[T]) Capture() *Environment[T] {
+	return &Environment[T]{
+		envs: append([]map[string]T{}, e.envs...),
+	}
+}
+
+// Outermost returns an environment sharing only the outermost scope of e,
+// which holds the globals.
+func (e *Environment[T]) Outermost() *Environment[T] {
+	return &Environment[T]{
+		envs: e.envs[:1:1],
+	}
+}
+
 func (e *Environment[T]) Assign(variable string, value T) {
 	env := e.findEnv(variable)
 	(*env)[variable] = value
@@ -40,12 +52,43 @@ func (e *Environment[T]) DeclareAssign(variable string, value T) {
 func (e *Environment[T]) Get(variable string) T {
 	env := e.findEnv(variable)
 	if env == nil {
-		fmt.Printf("Undefined variable: %s\n", variable)
-		os.Exit(1)
+		runtimePanic("Undefined variable: %s", variable)
 	}
 	return (*env)[variable]
 }
 
+// Depth returns the number of scopes, the outermost one included.
+func (e *Environment[T]) Depth() int {
+	return len(e.envs)
+}
+
+// ScopeOf returns the index of the innermost scope declaring variable, or -1
+// when it is not declared.
+func (e *Environment[T]) ScopeOf(variable string) int {
+	for k := len(e.envs) - 1; k >= 0; k-- {
+		if _, ok := e.envs[k][variable]; ok {
+			return k
+		}
+	}
+
+	return -1
+}
+
+// IsGlobal reports whether variable is found in the outermost scope.
+func (e *Environment[T]) IsGlobal(variable string) bool {
+	return e.findEnv(variable) == &e.envs[0]
+}
+
+// Lookup is like Get, but reports a missing variable instead of exiting.
+func (e *Environment[T]) Lookup(variable string) (T, bool) {
+	env := e.findEnv(variable)
+	if env == nil {
+		var zero T
+		return zero, false
+	}
+	return (*env)[variable], true
+}
+
 func (e *Environment[T]) findEnv(variable string) *map[string]T {
 	k := len(e.envs) - 1
 	for k >= 0 {
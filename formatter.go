@@ -0,0 +1,886 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Formatter prints a program in the canonical layout: four spaces of
+// indentation, one statement per line and opening braces on the line of the
+// statement they belong to. Comments and single blank lines are kept.
+type Formatter struct {
+	sb     strings.Builder
+	indent int
+
+	// tokens holds all tokens of the source with their comments in between.
+	tokens []Token
+	// comments holds the comments that are not printed yet.
+	comments []Token
+
+	// line is the source line of the statement being printed. Comments on it
+	// are printed at the end of the output line.
+	line int
+	// lineStart is set at the start of an output line, before its indentation.
+	lineStart bool
+	// blockStart is set before the first statement of a block, which never
+	// has a blank line in front of it.
+	blockStart bool
+}
+
+// Format parses source and returns it formatted.
+func Format(source io.Reader) (out string, err error) {
+	defer catchSyntaxError(&err)
+
+	tokens := NewLexer(source).ReadAllTokens()
+	f := &Formatter{
+		blockStart: true,
+	}
+
+	// Comments are put back in between the statements they stand next to
+	for _, t := range tokens {
+		f.comments = append(f.comments, t.Leading...)
+		f.tokens = append(f.tokens, t.Leading...)
+		f.tokens = append(f.tokens, t)
+		f.comments = append(f.comments, t.Trailing...)
+		f.tokens = append(f.tokens, t.Trailing...)
+	}
+
+	f.prog(NewParser(tokens).Parse())
+	return f.sb.String(), nil
+}
+
+func runFormatter(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "Write the result to the file instead of printing it")
+	check := flags.Bool("check", false, "Exit with 1 when a file is not formatted")
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Println("fmt expects a file")
+		os.Exit(1)
+	}
+
+	code := 0
+	for _, fileName := range flags.Args() {
+		source, err := os.ReadFile(fileName)
+		if err != nil {
+			fmt.Printf("Error opening file %s: %v\n", fileName, err)
+			os.Exit(1)
+		}
+
+		formatted, err := Format(bytes.NewReader(source))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if *check && formatted != string(source) {
+			fmt.Printf("%s is not formatted\n", fileName)
+			code = 1
+		}
+
+		if *write && formatted != string(source) {
+			if err := os.WriteFile(fileName, []byte(formatted), 0644); err != nil {
+				fmt.Printf("Error writing file %s: %v\n", fileName, err)
+				os.Exit(1)
+			}
+		} else if !*write && !*check {
+			fmt.Print(formatted)
+		}
+	}
+
+	os.Exit(code)
+}
+
+func (f *Formatter) prog(prog ProgStmt) {
+	f.leading(prog.Loc.Pos)
+	f.write("loc " + prog.Loc.Value + ";")
+	f.newline()
+
+	for _, glbl := range prog.Glbls {
+		f.leading(nodePos(glbl))
+		f.glbl(glbl)
+		f.newline()
+	}
+
+	f.flushComments(Pos{Line: math.MaxInt32})
+}
+
+// write adds s to the current output line.
+func (f *Formatter) write(s string) {
+	if f.lineStart {
+		f.sb.WriteString(strings.Repeat("    ", f.indent))
+		f.lineStart = false
+	}
+
+	f.sb.WriteString(s)
+}
+
+// newline ends the output line, after the comments on the source line of the
+// statement being printed.
+func (f *Formatter) newline() {
+	f.newlineBefore(Pos{Line: math.MaxInt32})
+}
+
+// newlineBefore ends the output line like newline, but leaves the comments
+// from pos on to the item there.
+func (f *Formatter) newlineBefore(pos Pos) {
+	for len(f.comments) > 0 && f.comments[0].Pos.Line <= f.line && before(f.comments[0].Pos, pos) {
+		f.write(" " + commentText(f.comments[0]))
+		f.line = f.comments[0].EndLine()
+		f.comments = f.comments[1:]
+	}
+
+	f.sb.WriteString("\n")
+	f.lineStart = true
+}
+
+// leading prints the comments in front of a statement at pos and a blank
+// line when the statement follows one.
+func (f *Formatter) leading(pos Pos) {
+	f.flushComments(pos)
+	f.blankLine(pos.Line)
+	f.line = pos.Line
+}
+
+// flushComments prints the comments before pos on their own lines.
+func (f *Formatter) flushComments(pos Pos) {
+	for len(f.comments) > 0 && before(f.comments[0].Pos, pos) {
+		c := f.comments[0]
+		f.comments = f.comments[1:]
+
+		f.blankLine(c.Pos.Line)
+		f.write(commentText(c))
+		f.line = c.EndLine()
+		f.newline()
+	}
+}
+
+// blankLine keeps the blank line in front of the source line, several blank
+// lines become one. Items on the line of the one before them have none.
+func (f *Formatter) blankLine(line int) {
+	k := sort.Search(len(f.tokens), func(k int) bool {
+		return f.tokens[k].Pos.Line >= line
+	})
+
+	if !f.blockStart && line != f.line && k > 0 && f.tokens[k-1].EndLine() < line-1 {
+		f.sb.WriteString("\n")
+	}
+	f.blockStart = false
+}
+
+// open starts the body of a block, a declaration or a literal with its
+// bracket. The first item of the body is at first.
+func (f *Formatter) open(bracket string, first Pos) {
+	f.write(bracket)
+	f.indent++
+	f.newlineBefore(first)
+	f.blockStart = true
+}
+
+// close ends the body started by open, after the comments before its closing
+// bracket at end.
+func (f *Formatter) close(bracket string, end Pos) {
+	f.flushComments(end)
+	f.blockStart = false
+	f.indent--
+	f.write(bracket)
+	f.line = end.Line
+}
+
+func (f *Formatter) block(b Block) {
+	if len(b.Stmts) == 0 && !(len(f.comments) > 0 && before(f.comments[0].Pos, b.End)) {
+		f.write("{}")
+		f.line = b.End.Line
+		return
+	}
+
+	f.open("{", firstPos(len(b.Stmts), func() Pos { return nodePos(b.Stmts[0]) }, b.End))
+	for k, stmt := range b.Stmts {
+		f.leading(nodePos(stmt))
+		f.stmt(stmt)
+		// A comment after the closing brace stays with it
+		f.newlineBefore(firstPos(len(b.Stmts)-k-1, func() Pos { return nodePos(b.Stmts[k+1]) }, b.End))
+	}
+	f.close("}", b.End)
+}
+
+func (f *Formatter) glbl(glbl GlblStmt) {
+	switch g := glbl.(type) {
+	case FuncDecl:
+		f.funcDecl(g)
+	case StructDecl:
+		f.write("struct " + g.Name + typeParamsString(g.TypeParams) + " ")
+		f.open("{", firstPos(len(g.Fields), func() Pos { return g.Fields[0].Pos }, g.End))
+		for _, field := range g.Fields {
+			f.leading(field.Pos)
+			f.write(field.Name + ": " + typeSourceOf(field.Type) + ",")
+			f.newline()
+		}
+		f.close("}", g.End)
+		f.write(";")
+	case EnumDecl:
+		f.write("enum " + g.Name + typeParamsString(g.TypeParams) + " ")
+		f.open("{", firstPos(len(g.Variants), func() Pos { return g.Variants[0].Pos }, g.End))
+		for _, variant := range g.Variants {
+			f.leading(variant.Pos)
+			f.write(variant.Name)
+			if len(variant.Fields) > 0 {
+				f.write("(" + strings.Join(Map(variant.Fields, typeSourceOf), ", ") + ")")
+			}
+			f.write(",")
+			f.newline()
+		}
+		f.close("}", g.End)
+	case TraitDecl:
+		f.write("trait " + g.Name + " ")
+		f.open("{", firstPos(len(g.Methods), func() Pos { return g.Methods[0].Pos }, g.End))
+		for _, method := range g.Methods {
+			f.leading(method.Pos)
+			f.funcSignature(method)
+			f.write(";")
+			f.newline()
+		}
+		f.close("}", g.End)
+	case ImplDecl:
+		f.write("impl" + typeParamsString(g.TypeParams) + " ")
+		if g.Trait != "" {
+			f.write(g.Trait + " for ")
+		}
+		f.write(typeSourceOf(g.Target) + " ")
+		f.open("{", firstPos(len(g.Methods), func() Pos { return g.Methods[0].Pos }, g.End))
+		for _, method := range g.Methods {
+			f.leading(method.Pos)
+			f.funcDecl(method)
+			f.newline()
+		}
+		f.close("}", g.End)
+	case TypeDecl:
+		f.write("type " + g.Name + " = ")
+		if g.IsDistinct {
+			f.write("distinct ")
+		}
+		f.write(typeSourceOf(g.Type) + ";")
+	case Stmt:
+		f.stmt(g)
+	}
+}
+
+func (f *Formatter) funcDecl(decl FuncDecl) {
+	if decl.IsConst {
+		f.write("const ")
+	}
+	f.funcSignature(decl)
+	f.write(" ")
+	f.block(decl.Body)
+}
+
+func (f *Formatter) funcSignature(decl FuncDecl) {
+	f.write("fun " + decl.Name + typeParamsString(decl.TypeParams))
+	// Parameters with comments among them are written one per line
+	f.list(decl.Pos, "(", ")", false, len(decl.Params), func(k int) {
+		f.param(decl.Params[k])
+	})
+	f.returnType(decl.ReturnType)
+}
+
+func (f *Formatter) params(params []FuncParam) {
+	f.write("(")
+	for k, param := range params {
+		if k > 0 {
+			f.write(", ")
+		}
+		f.param(param)
+	}
+	f.write(")")
+}
+
+func (f *Formatter) param(param FuncParam) {
+	// `self` and `&self` have no written type
+	if param.Name == "self" && param.Type.Source == "" {
+		if param.Type.IsRef {
+			f.write("&")
+		}
+		f.write("self")
+		return
+	}
+
+	f.write(param.Name)
+	if param.IsVariadic {
+		f.write(": ..." + typeSourceOf(param.Type.Elems[0]))
+	} else {
+		f.varType(param.Type)
+	}
+
+	if param.Default != nil {
+		f.write(" = ")
+		f.expr(param.Default)
+	}
+}
+
+func (f *Formatter) returnType(typ TypeSpec) {
+	if typ.Kind != NoType {
+		f.write(" -> " + typeSourceOf(typ))
+	}
+}
+
+// varType writes the type of a variable, if it has one.
+func (f *Formatter) varType(typ TypeSpec) {
+	if typ.Kind != NoType {
+		f.write(": " + typeSourceOf(typ))
+	}
+}
+
+func (f *Formatter) stmt(stmt Stmt) {
+	switch s := stmt.(type) {
+	case VarDecl:
+		f.write("var " + s.Name)
+		f.varType(s.Type)
+		f.init(s.Init)
+	case ConstDecl:
+		f.write("const " + s.Name)
+		f.varType(s.Type)
+		f.init(s.Init)
+	case TupleVarDecl:
+		f.write("var (" + strings.Join(s.Names, ", ") + ")")
+		f.varType(s.Type)
+		f.init(s.Init)
+	case ExprStmt:
+		f.expr(s.Expr)
+		// A match used as a statement does not need a semicolon
+		if _, ok := s.Expr.(MatchExpr); !ok {
+			f.write(";")
+		}
+	case AssignStmt:
+		f.expr(s.Target)
+		if s.IsPostfix {
+			f.write(s.Op + s.Op + ";")
+			break
+		}
+		f.write(" " + s.Op + "= ")
+		f.expr(s.Value)
+		f.write(";")
+	case ReturnStmt:
+		f.write("return")
+		// `return a, b;` returns a tuple
+		if tuple, ok := s.Value.(TupleLit); ok && len(tuple.Elems) > 1 {
+			f.write(" ")
+			f.exprs(tuple.Elems)
+		} else if s.Value != nil {
+			f.write(" ")
+			f.expr(s.Value)
+		}
+		f.write(";")
+	case DeferStmt:
+		f.write("defer ")
+		f.expr(s.Expr)
+		f.write(";")
+	case IfStmt:
+		f.write("if ")
+		f.expr(s.Condition)
+		f.write(" ")
+		f.block(s.ThenBody)
+		if s.HasElse {
+			f.write(" else ")
+			f.block(s.ElseBody)
+		}
+	case WhileStmt:
+		f.write("while ")
+		f.expr(s.Condition)
+		f.write(" ")
+		f.block(s.Body)
+	case ForStmt:
+		f.write("for ")
+		if s.Key != "" {
+			f.write(s.Key + ", ")
+		}
+		f.write(s.Value + " in ")
+		f.expr(s.Iterable)
+		f.write(" ")
+		f.block(s.Body)
+	}
+}
+
+// init writes the initializer of a variable and the closing semicolon.
+func (f *Formatter) init(init Expr) {
+	f.write(" =")
+	if init != nil {
+		f.write(" ")
+		f.expr(init)
+	}
+	f.write(";")
+}
+
+func (f *Formatter) expr(expr Expr) {
+	if pos, ok := exprStart(expr); ok {
+		f.inline(pos)
+	}
+
+	switch e := expr.(type) {
+	case IntLit:
+		f.write(f.literal(e.Pos, strconv.Itoa(e.Value)+e.Type))
+	case FloatLit:
+		f.write(f.literal(e.Pos, strconv.FormatFloat(e.Value, 'f', -1, 64)+e.Type))
+	case StrLit:
+		f.write(f.literal(e.Pos, `"`+escape(e.Value, '"')+`"`))
+	case CharLit:
+		f.write(f.literal(e.Pos, "'"+escape(string(e.Value), '\'')+"'"))
+	case InterpStr:
+		f.write(f.literal(e.Pos, ""))
+	case BoolLit:
+		f.write(strconv.FormatBool(e.Value))
+	case NilLit:
+		f.write("nil")
+	case Variable:
+		f.write(e.Name)
+	case FuncCall:
+		if (e.IsArithmetic || e.IsComparison) && len(e.Args) == 2 {
+			f.expr(e.Args[0])
+			f.inline(e.Pos)
+			f.write(" " + e.Name + " ")
+			f.expr(e.Args[1])
+		} else if e.IsArithmetic {
+			f.write(e.Name)
+			f.expr(e.Args[0])
+		} else {
+			f.write(e.Name)
+			f.args(e.Args)
+		}
+	case NamedArg:
+		f.write(e.Name + ": ")
+		f.expr(e.Value)
+	case CallExpr:
+		f.expr(e.Callee)
+		f.args(e.Args)
+	case MethodCall:
+		f.expr(e.Receiver)
+		f.write(dot(e.Optional) + e.Method)
+		f.args(e.Args)
+	case FieldExpr:
+		f.expr(e.Expr)
+		f.write(dot(e.Optional) + e.Field)
+	case IndexExpr:
+		f.expr(e.Expr)
+		f.write("[")
+		f.expr(e.Index)
+		f.write("]")
+	case TryExpr:
+		f.expr(e.Expr)
+		f.write("?")
+	case RecoverExpr:
+		f.write("try ")
+		f.expr(e.Call)
+	case RefExpr:
+		f.write("&")
+		f.expr(e.Expr)
+	case DerefExpr:
+		f.write("*")
+		f.expr(e.Expr)
+	case CoalesceExpr:
+		f.expr(e.Left)
+		f.write(" ?? ")
+		f.expr(e.Right)
+	case RangeExpr:
+		f.expr(e.Start)
+		if e.Inclusive {
+			f.write("..=")
+		} else {
+			f.write("..")
+		}
+		f.expr(e.End)
+	case ParenExpr:
+		f.write("(")
+		f.expr(e.Expr)
+		f.write(")")
+	case TupleLit:
+		f.write("(")
+		f.exprs(e.Elems)
+		// `(a,)` is a tuple with a single element
+		if len(e.Elems) == 1 {
+			f.write(",")
+		}
+		f.write(")")
+	case ArrayLit:
+		f.list(e.Pos, "[", "]", false, len(e.Elems), func(k int) {
+			f.expr(e.Elems[k])
+		})
+	case MapLit:
+		f.write(typeSourceOf(e.Type))
+		f.list(e.Pos, "{", "}", false, len(e.Keys), func(k int) {
+			f.expr(e.Keys[k])
+			f.write(": ")
+			f.expr(e.Values[k])
+		})
+	case StructLit:
+		f.write(e.Name + " ")
+		f.list(e.Pos, "{", "}", true, len(e.Fields), func(k int) {
+			f.structLitField(e.Fields[k])
+		})
+	case FuncLit:
+		f.funcLit(e)
+	case MatchExpr:
+		f.matchExpr(e)
+	}
+}
+
+// exprs writes a comma separated list of expressions.
+func (f *Formatter) exprs(exprs []Expr) {
+	for k, expr := range exprs {
+		if k > 0 {
+			f.write(", ")
+		}
+		f.expr(expr)
+	}
+}
+
+func (f *Formatter) args(args []Expr) {
+	f.write("(")
+	f.exprs(args)
+	f.write(")")
+}
+
+// list writes the n items of the literal at pos between its brackets, one
+// per line when they start on the line after the open bracket in the source
+// or when comments are among them. Spaced literals have spaces inside of the
+// brackets on a single line.
+func (f *Formatter) list(pos Pos, open, close string, spaced bool, n int, item func(k int)) {
+	items, start, end := f.items(pos, open)
+	commented := len(f.comments) > 0 && before(f.comments[0].Pos, end)
+	if n == 0 || (items[0].Line == start.Line && !commented) {
+		f.write(open)
+		for k := 0; k < n; k++ {
+			if k == 0 && spaced {
+				f.write(" ")
+			} else if k > 0 {
+				f.write(", ")
+			}
+			item(k)
+		}
+		if n > 0 && spaced {
+			f.write(" ")
+		}
+		f.write(close)
+		return
+	}
+
+	f.open(open, items[0])
+	for k := 0; k < n; k++ {
+		f.leading(items[k])
+		item(k)
+		f.write(",")
+		f.newlineBefore(firstPos(n-k-1, func() Pos { return items[k+1] }, end))
+	}
+	f.close(close, end)
+}
+
+// items returns the positions the items of the literal at pos start at, and
+// the positions of its open and its closing bracket.
+func (f *Formatter) items(pos Pos, open string) ([]Pos, Pos, Pos) {
+	k := f.tokenAt(pos)
+	for f.tokens[k].Raw != open {
+		k++
+	}
+	start := f.tokens[k].Pos
+
+	items := make([]Pos, 0)
+	depth := 0
+	next := true
+	for k++; k < len(f.tokens); k++ {
+		t := f.tokens[k]
+		switch t.Type {
+		case LCOMMENT, BCOMMENT:
+			continue
+		case RPAREN, RCURLY, RBRACKET:
+			if depth == 0 {
+				return items, start, t.Pos
+			}
+			depth--
+		case COMMA:
+			if depth == 0 {
+				next = true
+				continue
+			}
+		}
+
+		if next {
+			items = append(items, t.Pos)
+			next = false
+		}
+
+		switch t.Type {
+		case LPAREN, LCURLY, LBRACKET:
+			depth++
+		}
+	}
+
+	return items, start, Pos{}
+}
+
+func (f *Formatter) structLitField(field StructLitField) {
+	// `Vec2 { x }` is short for `Vec2 { x: x }`
+	if v, ok := field.Value.(Variable); ok && v.Name == field.Name {
+		f.write(field.Name)
+		return
+	}
+
+	f.write(field.Name + ": ")
+	f.expr(field.Value)
+}
+
+func (f *Formatter) funcLit(lit FuncLit) {
+	if !lit.IsLambda {
+		f.write("fun")
+		f.params(lit.Params)
+		f.returnType(lit.ReturnType)
+		f.write(" ")
+		f.block(lit.Body)
+		return
+	}
+
+	f.write("|")
+	for k, param := range lit.Params {
+		if k > 0 {
+			f.write(", ")
+		}
+		f.param(param)
+	}
+	f.write("|")
+
+	if lit.IsExprBody {
+		f.write(" ")
+		f.expr(lit.Body.Stmts[0].(ReturnStmt).Value)
+		return
+	}
+
+	f.returnType(lit.ReturnType)
+	f.write(" ")
+	f.block(lit.Body)
+}
+
+func (f *Formatter) matchExpr(match MatchExpr) {
+	f.write("match ")
+	f.expr(match.Subject)
+	f.write(" ")
+	f.open("{", firstPos(len(match.Arms), func() Pos { return match.Arms[0].Pos }, match.End))
+
+	for _, arm := range match.Arms {
+		f.leading(arm.Pos)
+		f.pattern(arm.Pattern)
+		if arm.Guard != nil {
+			f.write(" if ")
+			f.expr(arm.Guard)
+		}
+		f.write(" => ")
+
+		// Block arms leave out the comma
+		if body, ok := arm.Body.(Block); ok {
+			f.block(body)
+		} else {
+			f.expr(arm.Body.(Expr))
+			f.write(",")
+		}
+		f.newline()
+	}
+
+	f.close("}", match.End)
+}
+
+func (f *Formatter) pattern(pattern Pattern) {
+	switch p := pattern.(type) {
+	case BindingPattern:
+		f.write(p.Name)
+	case WildcardPattern:
+		f.write("_")
+	case LiteralPattern:
+		f.expr(p.Value)
+	case TuplePattern:
+		f.write("(")
+		f.patterns(p.Elems)
+		f.write(")")
+	case VariantPattern:
+		f.write(p.Enum + "::" + p.Variant)
+		if len(p.Fields) > 0 {
+			f.write("(")
+			f.patterns(p.Fields)
+			f.write(")")
+		}
+	}
+}
+
+func (f *Formatter) patterns(patterns []Pattern) {
+	for k, pattern := range patterns {
+		if k > 0 {
+			f.write(", ")
+		}
+		f.pattern(pattern)
+	}
+}
+
+// literal returns the literal at pos as it is written in the source, or
+// fallback when it is not from the source.
+func (f *Formatter) literal(pos Pos, fallback string) string {
+	if k := f.tokenAt(pos); k < len(f.tokens) && f.tokens[k].Pos.Line == pos.Line && f.tokens[k].Pos.Col == pos.Col {
+		return f.tokens[k].Raw
+	}
+
+	return fallback
+}
+
+// tokenAt returns the index of the first token at or after pos.
+func (f *Formatter) tokenAt(pos Pos) int {
+	return sort.Search(len(f.tokens), func(k int) bool {
+		return !before(f.tokens[k].Pos, pos)
+	})
+}
+
+// inline writes the block comments before pos where they are in the
+// expression being written, like the one in `f(1, /* two */ 2)`. A line
+// comment ends the line, it is left for newline.
+func (f *Formatter) inline(pos Pos) {
+	for len(f.comments) > 0 && f.comments[0].Type == BCOMMENT && before(f.comments[0].Pos, pos) {
+		text := commentText(f.comments[0])
+		f.comments = f.comments[1:]
+
+		// After an operand the comment follows it, otherwise it leads the next one
+		if out := f.sb.String(); f.lineStart || strings.HasSuffix(out, " ") || strings.HasSuffix(out, "(") || strings.HasSuffix(out, "[") {
+			f.write(text + " ")
+		} else {
+			f.write(" " + text)
+		}
+	}
+}
+
+// exprStart returns the position an expression starts at, when the
+// expression knows it.
+func exprStart(expr Expr) (Pos, bool) {
+	switch e := expr.(type) {
+	case IntLit:
+		return e.Pos, true
+	case FloatLit:
+		return e.Pos, true
+	case StrLit:
+		return e.Pos, true
+	case CharLit:
+		return e.Pos, true
+	case InterpStr:
+		return e.Pos, true
+	case Variable:
+		return e.Pos, true
+	case DerefExpr:
+		return e.Pos, true
+	case FuncCall:
+		// The position of `a + b` is the one of its operator
+		return e.Pos, !(e.IsArithmetic || e.IsComparison) || len(e.Args) == 1
+	}
+
+	return Pos{}, false
+}
+
+// nodePos returns the position a statement or a declaration starts at.
+func nodePos(node Node) Pos {
+	switch n := node.(type) {
+	case VarDecl:
+		return n.Pos
+	case ConstDecl:
+		return n.Pos
+	case TupleVarDecl:
+		return n.Pos
+	case ExprStmt:
+		return n.Pos
+	case AssignStmt:
+		return n.Pos
+	case ReturnStmt:
+		return n.Pos
+	case DeferStmt:
+		return n.Pos
+	case IfStmt:
+		return n.Pos
+	case WhileStmt:
+		return n.Pos
+	case ForStmt:
+		return n.Pos
+	case FuncDecl:
+		return n.Pos
+	case StructDecl:
+		return n.Pos
+	case EnumDecl:
+		return n.Pos
+	case TraitDecl:
+		return n.Pos
+	case ImplDecl:
+		return n.Pos
+	case TypeDecl:
+		return n.Pos
+	}
+
+	return Pos{}
+}
+
+// firstPos returns the position of the first of n items, or end when there
+// are none.
+func firstPos(n int, first func() Pos, end Pos) Pos {
+	if n == 0 {
+		return end
+	}
+
+	return first()
+}
+
+func before(a, b Pos) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+}
+
+func commentText(t Token) string {
+	return strings.TrimRightFunc(t.Raw, unicode.IsSpace)
+}
+
+// typeSourceOf returns a type as it is written in the source.
+func typeSourceOf(typ TypeSpec) string {
+	if typ.Source != "" {
+		return typ.Source
+	}
+
+	return typ.String()
+}
+
+func dot(optional bool) string {
+	if optional {
+		return "?."
+	}
+
+	return "."
+}
+
+// escape writes the escape sequences of s, which is quoted with quote.
+func escape(s string, quote rune) string {
+	sb := strings.Builder{}
+	for _, r := range s {
+		switch {
+		case r == quote || r == '\\' || (quote == '"' && (r == '{' || r == '}')):
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == 0:
+			sb.WriteString(`\0`)
+		case !unicode.IsPrint(r):
+			sb.WriteString(fmt.Sprintf(`\u{%x}`, r))
+		default:
+			sb.WriteRune(r)
+		}
+	}
+
+	return sb.String()
+}
@@ -1,7 +1,97 @@
 package main
 
+import "fmt"
+
 type FunctionDefinition struct {
 	Name   string
 	Params []FuncParam
 	Body   Block
+	// Env holds the scopes the function was defined in. The body runs in them,
+	// so closures keep using the variables of the function that created them.
+	Env *Environment[*AvaVar]
+}
+
+func (f FunctionDefinition) String() string {
+	return "fun " + f.Name
+}
+
+// bindArgs matches the arguments of a call to the parameters of the callee
+// and returns the index of the parameter of each argument. Positional
+// arguments fill the parameters in order and a variadic parameter takes all
+// the positional arguments left, named arguments fill the parameter of their
+// name. Parameters without an argument must have a default value.
+func bindArgs(name string, params []FuncParam, args []Expr) ([]int, error) {
+	slots := make([]int, len(args))
+	bound := make([]bool, len(params))
+	named := false
+
+	for k, arg := range args {
+		if n, ok := arg.(NamedArg); ok {
+			named = true
+			slot := paramIndex(params, n.Name)
+			if slot < 0 {
+				return nil, fmt.Errorf("Function %s has no parameter %s", name, n.Name)
+			} else if bound[slot] {
+				return nil, fmt.Errorf("Parameter %s of %s is given more than once", n.Name, name)
+			}
+
+			slots[k] = slot
+			bound[slot] = true
+			continue
+		}
+
+		if named {
+			return nil, fmt.Errorf("Positional arguments of %s must come before named ones", name)
+		}
+
+		slot := k
+		if len(params) > 0 && params[len(params)-1].IsVariadic && slot >= len(params)-1 {
+			slot = len(params) - 1
+		} else if slot >= len(params) {
+			return nil, arityError(name, params, len(args))
+		}
+
+		slots[k] = slot
+		bound[slot] = true
+	}
+
+	for k, param := range params {
+		if bound[k] || param.Default != nil || param.IsVariadic {
+			continue
+		}
+
+		if !named {
+			return nil, arityError(name, params, len(args))
+		}
+		return nil, fmt.Errorf("Missing argument %s of %s", param.Name, name)
+	}
+
+	return slots, nil
+}
+
+// arityError reports a call with the wrong number of positional arguments.
+func arityError(name string, params []FuncParam, got int) error {
+	required := 0
+	for _, param := range params {
+		if param.Default == nil && !param.IsVariadic {
+			required++
+		}
+	}
+
+	if got < required && required < len(params) {
+		return fmt.Errorf("Function %s expects at least %d arguments, but got %d", name, required, got)
+	} else if got > len(params) && required < len(params) {
+		return fmt.Errorf("Function %s expects at most %d arguments, but got %d", name, len(params), got)
+	}
+	return fmt.Errorf("Function %s expects %d arguments, but got %d", name, len(params), got)
+}
+
+func paramIndex(params []FuncParam, name string) int {
+	for k, param := range params {
+		if param.Name == name {
+			return k
+		}
+	}
+
+	return -1
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+type globalState int
+
+const (
+	unchecked globalState = iota
+	checking
+	checked
+)
+
+// globalName returns the name of a global variable or const declaration.
+func globalName(glbl GlblStmt) (string, bool) {
+	switch decl := glbl.(type) {
+	case VarDecl:
+		return decl.Name, true
+	case ConstDecl:
+		return decl.Name, true
+	}
+
+	return "", false
+}
+
+// checkGlobal checks the declaration of a global variable or const the first
+// time it is used, so globals can be used before they are declared.
+func (c *Checker) checkGlobal(name string) {
+	decl, ok := c.globals[name]
+	if !ok || c.globalStates[name] != unchecked {
+		return
+	}
+	c.globalStates[name] = checking
+
+	// The initializer sees the global scope only, even when the global is
+	// used inside a lambda.
+	env, returnType, global := c.environment, c.returnType, c.global
+	c.environment = env.Outermost()
+	c.returnType = TypeSpec{}
+	c.global = name
+
+	c.Visit(decl)
+
+	c.environment, c.returnType, c.global = env, returnType, global
+	c.globalStates[name] = checked
+}
+
+// lookup finds a variable and records the use of a global as a dependency
+// of the initializer or function being checked.
+func (c *Checker) lookup(name string) (checkedVar, bool) {
+	if v, ok := c.environment.Lookup(name); ok {
+		if c.environment.IsGlobal(name) {
+			c.dependOn(name)
+		}
+		return v, true
+	}
+
+	if _, ok := c.globals[name]; !ok {
+		return checkedVar{}, false
+	}
+
+	c.dependOn(name)
+	if c.globalStates[name] == checking {
+		// A cycle, which is reported once all dependencies are known
+		return checkedVar{
+			Type: anyType,
+		}, true
+	}
+
+	c.checkGlobal(name)
+	return c.environment.Lookup(name)
+}
+
+// dependOn records that the initializer or function being checked uses a
+// global, a function or a method, which is named like `Vec2::len`.
+func (c *Checker) dependOn(name string) {
+	node := c.function
+	if node == "" {
+		node = c.global
+	}
+
+	if node != "" && !contains(c.deps[node], name) {
+		c.deps[node] = append(c.deps[node], name)
+	}
+}
+
+// dependOnMethod records a call of a method. Calls on trait objects and type
+// parameters may run the method of any type.
+func (c *Checker) dependOnMethod(recv TypeSpec, method string) {
+	if recv.Kind == NamedType && !c.isTypeParam(recv) {
+		c.dependOn(recv.Name + "::" + method)
+		return
+	}
+
+	types := make([]string, 0)
+	for typ, methods := range c.methods {
+		if _, ok := methods[method]; ok {
+			types = append(types, typ)
+		}
+	}
+	sort.Strings(types)
+
+	for _, typ := range types {
+		c.dependOn(typ + "::" + method)
+	}
+}
+
+// orderGlobals sorts the globals so each is initialized after the globals
+// its initializer uses, also through the functions it calls. Globals that do
+// not depend on each other keep the order they are declared in.
+func (c *Checker) orderGlobals() {
+	done := make(map[string]bool)
+	active := make(map[string]bool)
+
+	var visit func(name string, path []string)
+	visit = func(name string, path []string) {
+		if done[name] {
+			return
+		} else if active[name] {
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			cycle := append(append([]string{}, path[start:]...), name)
+			c.errorf("Initialization cycle: %s", strings.Join(cycle, " -> "))
+			return
+		}
+
+		active[name] = true
+		path = append(path, name)
+
+		// Functions are followed once, recursion does not make a cycle
+		seen := make(map[string]bool)
+		var walk func(node string, path []string)
+		walk = func(node string, path []string) {
+			for _, dep := range c.deps[node] {
+				if _, ok := c.globals[dep]; ok {
+					visit(dep, path)
+				} else if !seen[dep] {
+					seen[dep] = true
+					walk(dep, append(path[:len(path):len(path)], dep))
+				}
+			}
+		}
+		walk(name, path)
+
+		active[name] = false
+		done[name] = true
+		c.initOrder = append(c.initOrder, c.globals[name])
+	}
+
+	for _, glbl := range c.tree.Glbls {
+		if name, ok := globalName(glbl); ok {
+			visit(name, nil)
+		}
+	}
+}
+
+// checkInit checks the signature of `init`, which runs before main.
+func (c *Checker) checkInit() {
+	decl, ok := c.functions["init"]
+	if ok && (len(decl.Params) > 0 || len(decl.TypeParams) > 0 || !isVoid(decl.ReturnType)) {
+		c.errorf("Function init must not take parameters or return a value")
+	}
+}
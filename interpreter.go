@@ -5,57 +5,192 @@ import (
 	"io"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 type Interp struct {
 	tree ProgStmt
 
-	environment *Environment[AvaVar]
+	environment *Environment[*AvaVar]
 	functions   map[string]FunctionDefinition
 	structs     map[string]StructDefinition
+	enums       map[string]EnumDefinition
+	// types maps the names declared with `type` to the types they stand for.
+	types map[string]TypeSpec
+	// methods maps a struct or enum name to its methods, including the ones
+	// implementing traits, so calls dispatch on the type of the receiver.
+	methods map[string]map[string]FunctionDefinition
+
+	// returnValue is set by a return statement and stays set until the
+	// surrounding function call picks it up, which unwinds blocks and loops.
+	returnValue *AvaVal
+
+	// callStack holds the functions being run, the innermost last.
+	callStack []stackFrame
+
+	// globals holds the global variables and consts in the order they are
+	// initialized in, which the checker finds. Without it they are
+	// initialized in the order they are declared in.
+	globals []GlblStmt
+}
+
+// earlyReturn is panicked by `?` on an error. It can happen in the middle of
+// an expression, so it unwinds the Go stack up to the function call.
+type earlyReturn struct {
+	value AvaVal
 }
 
 func (i *Interp) VisitExprStmt(stmt ExprStmt) AvaVal {
 	return i.Visit(stmt.Expr)
 }
 
+func (i *Interp) VisitDeferStmt(stmt DeferStmt) AvaVal {
+	frame := &i.callStack[len(i.callStack)-1]
+	frame.Deferred = append(frame.Deferred, deferredExpr{
+		Expr: stmt.Expr,
+		Env:  i.environment.Capture(),
+	})
+
+	return AvaVal{
+		Type: Void,
+	}
+}
+
+func (i *Interp) VisitReturnStmt(stmt ReturnStmt) AvaVal {
+	val := AvaVal{
+		Type: Void,
+	}
+	if stmt.Value != nil {
+		val = i.Visit(stmt.Value)
+	}
+
+	i.returnValue = &val
+	return val
+}
+
 func NewInterpretator(source io.Reader) *Interp {
 	tree := CreateAst(source)
 
 	if IsDebug {
 		fmt.Println(tree.String())
 	}
+	tree = withPrelude(tree)
 
+	checker := NewChecker(tree)
+	if errs := checker.Check(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		os.Exit(1)
+	}
+
+	interp := newInterp(tree)
+	interp.globals = checker.initOrder
+
+	return interp
+}
+
+func newInterp(tree ProgStmt) *Interp {
 	return &Interp{
 		tree:        tree,
-		environment: NewEnvironment[AvaVar](),
+		environment: NewEnvironment[*AvaVar](),
 		functions:   make(map[string]FunctionDefinition),
 		structs:     make(map[string]StructDefinition),
+		enums:       make(map[string]EnumDefinition),
+		types:       make(map[string]TypeSpec),
+		methods:     make(map[string]map[string]FunctionDefinition),
 	}
 }
 
-func (i *Interp) Run() {
+// Run runs the program and returns its exit code, which main can return.
+func (i *Interp) Run() int {
+	defer i.reportPanic()
+
 	i.VisitProgStmt(i.tree)
 
-	if _, ok := i.functions["main"]; ok {
-		funcCall := FuncCall{
-			Name: "main",
+	if _, ok := i.functions["init"]; ok {
+		i.VisitFuncCall(FuncCall{
+			Name: "init",
 			Args: []Expr{},
-		}
-		i.VisitFuncCall(funcCall)
+		})
+	}
+
+	main, ok := i.functions["main"]
+	if !ok {
+		fmt.Println("Source code does not contain main function.")
+		return 1
+	}
+
+	// `fun main(args: []str)` receives the arguments of the program
+	args := make([]AvaVal, 0)
+	if len(main.Params) == 1 {
+		args = append(args, AvaVal{
+			Type: Array,
+			Value: Map(programArgs, func(arg string) AvaVal {
+				return AvaVal{
+					Type:  String,
+					Value: arg,
+				}
+			}),
+		})
+	}
+
+	// An integer returned by main is the exit code
+	if code, ok := i.runFunction(main, args).Value.(int); ok {
+		return code
+	}
+	return 0
+}
+
+// reportPanic ends the program on a panic that was not caught by `try` and
+// prints where each function on the stack was at.
+func (i *Interp) reportPanic() {
+	r := recover()
+	if r == nil {
 		return
 	}
 
-	fmt.Println("Source code does not contain main function.")
+	err, ok := r.(RuntimeError)
+	if !ok {
+		panic(r)
+	}
+
+	printPanic(os.Stdout, err)
 	os.Exit(1)
 }
 
+func printPanic(out io.Writer, err RuntimeError) {
+	fmt.Fprintf(out, "panic: %s\n", err.Message)
+	for k := len(err.Trace) - 1; k >= 0; k-- {
+		fmt.Fprintf(out, "    %s\n", err.Trace[k])
+	}
+}
+
+// at records that the running function has reached pos.
+func (i *Interp) at(pos Pos) {
+	if len(i.callStack) > 0 {
+		i.callStack[len(i.callStack)-1].Pos = pos
+	}
+}
+
 func (i *Interp) Visit(node Node) AvaVal {
 	return node.Accept(i)
 }
 
 func (i *Interp) VisitProgStmt(stmt ProgStmt) AvaVal {
+	// Functions and types are declared first, so initializers of globals can
+	// use the ones declared after them.
+	globals := i.globals
 	for _, glbl := range stmt.Glbls {
+		if _, ok := globalName(glbl); !ok {
+			i.Visit(glbl)
+		} else if i.globals == nil {
+			globals = append(globals, glbl)
+		}
+	}
+
+	for _, glbl := range globals {
 		i.Visit(glbl)
 	}
 
@@ -70,102 +205,277 @@ func (i *Interp) VisitParenExpr(expr ParenExpr) AvaVal {
 	return i.Visit(expr.Expr)
 }
 
+func (i *Interp) VisitNamedArg(arg NamedArg) AvaVal {
+	return i.Visit(arg.Value)
+}
+
 func (i *Interp) visitArithmeticCall(call FuncCall) AvaVal {
+	if len(call.Args) == 1 && call.Name == "-" {
+		val := deref(i.Visit(call.Args[0]))
+		i.at(call.Pos)
+		return i.negate(val)
+	}
+
 	if len(call.Args) != 2 {
-		fmt.Printf("Arithmetic operation requires exactly 2 arguments, but got %d. (Possible parser bug)\n", len(call.Args))
-		os.Exit(1)
+		runtimePanic("Arithmetic operation requires exactly 2 arguments, but got %d. (Possible parser bug)", len(call.Args))
 	}
 	args := Map(call.Args, func(arg Expr) AvaVal {
-		return i.Visit(arg)
+		return deref(i.Visit(arg))
 	})
+	i.at(call.Pos)
 
-	a := args[0]
-	b := args[1]
+	return i.arithmetic(call.Name, args[0], args[1])
+}
 
-	if a.Type != b.Type {
-		fmt.Printf("Arithmetic operation arguments must be same! Received types %d and %d\n", a.Type, b.Type)
-		os.Exit(1)
+// arithmetic applies a binary operator, which is also used by compound assignments.
+func (i *Interp) arithmetic(op string, a AvaVal, b AvaVal) AvaVal {
+	if a.Type == Struct || a.Type == Enum {
+		return i.callOperator(operatorTraits[op].Method, a, b)
 	}
 
-	if a.Type != Int {
-		fmt.Printf("Custom type arithmetic operation is not implemented yet.")
-		os.Exit(1)
+	if a.Type != b.Type {
+		runtimePanic("Arithmetic operation arguments must be same! Received types %s and %s", a.Type, b.Type)
 	}
 
-	aInt, bInt := 0, 0
-	var ok bool
-	if aInt, ok = a.Value.(int); !ok {
-		fmt.Printf("Could not cast value to int in arithmetic operation %s\n", call.Name)
-		os.Exit(1)
-	}
-	if bInt, ok = b.Value.(int); !ok {
-		fmt.Printf("Could not cast value to int in arithmetic operation %s\n", call.Name)
-		os.Exit(1)
+	switch a.Type {
+	case Int:
+		return intVal(intArithmetic(op, a.Value.(int), b.Value.(int)))
+	case Float:
+		x, y := a.Value.(float64), b.Value.(float64)
+
+		val := 0.0
+		switch op {
+		case "+":
+			val = x + y
+		case "-":
+			val = x - y
+		case "*":
+			val = x * y
+		case "/":
+			val = x / y
+		default:
+			runtimePanic("Unsupported arithmetic operation on floats: %s", op)
+		}
+
+		return AvaVal{
+			Type:  Float,
+			Value: val,
+		}
+	case String:
+		if op != "+" {
+			runtimePanic("Unsupported arithmetic operation on strings: %s", op)
+		}
+
+		return AvaVal{
+			Type:  String,
+			Value: a.Value.(string) + b.Value.(string),
+		}
+	case Bool:
+		x, y := a.Value.(bool), b.Value.(bool)
+
+		val := false
+		switch op {
+		case "&":
+			val = x && y
+		case "|":
+			val = x || y
+		case "^":
+			val = x != y
+		default:
+			runtimePanic("Unsupported arithmetic operation on bools: %s", op)
+		}
+
+		return AvaVal{
+			Type:  Bool,
+			Value: val,
+		}
 	}
 
-	val := 0
-	switch call.Name {
+	runtimePanic("Custom type arithmetic operation is not implemented yet.")
+	return AvaVal{}
+}
+
+func intArithmetic(op string, a int, b int) int {
+	switch op {
 	case "+":
-		val = aInt + bInt
-	default:
-		fmt.Printf("Unsupported arithmetic operation: %s\n", call.Name)
-		os.Exit(1)
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/", "%":
+		if b == 0 {
+			runtimePanic("Division by zero")
+		}
+		if op == "%" {
+			return a % b
+		}
+		return a / b
+	case "&":
+		return a & b
+	case "|":
+		return a | b
+	case "^":
+		return a ^ b
+	case "<<", ">>":
+		if b < 0 {
+			runtimePanic("Negative shift amount %d", b)
+		}
+		if op == "<<" {
+			return a << b
+		}
+		return a >> b
 	}
 
-	return AvaVal{
-		Type:  Int,
-		Value: val,
+	runtimePanic("Unsupported arithmetic operation: %s", op)
+	return 0
+}
+
+func (i *Interp) negate(val AvaVal) AvaVal {
+	if val.Type == Struct || val.Type == Enum {
+		return i.callOperator("neg", val)
 	}
+
+	switch n := val.Value.(type) {
+	case int:
+		return intVal(-n)
+	case float64:
+		return AvaVal{
+			Type:  Float,
+			Value: -n,
+		}
+	}
+
+	runtimePanic("Cannot negate value of type %s", val.Type)
+	return AvaVal{}
 }
 
 func (i *Interp) visitComparisonCall(call FuncCall) AvaVal {
 	if len(call.Args) != 2 {
-		fmt.Printf("Comparison requires exactly 2 arguments, but got %d. (Possible parser bug)\n", len(call.Args))
-		os.Exit(1)
+		runtimePanic("Comparison requires exactly 2 arguments, but got %d. (Possible parser bug)", len(call.Args))
+	}
+
+	// `&&` and `||` do not evaluate the right side when the left side decides the result.
+	if call.Name == "&&" || call.Name == "||" {
+		a := i.Visit(call.Args[0])
+		if a.Type != Bool {
+			runtimePanic("Operator %s requires bool values, but got %s", call.Name, a.Type)
+		}
+
+		if a.Value.(bool) == (call.Name == "||") {
+			return a
+		}
+
+		return i.Visit(call.Args[1])
 	}
+
 	args := Map(call.Args, func(arg Expr) AvaVal {
-		return i.Visit(arg)
+		return deref(i.Visit(arg))
 	})
+	i.at(call.Pos)
 
 	a := args[0]
 	b := args[1]
 
+	// Only nil compares with values of other types.
+	if a.Type != b.Type && (a.Type == Nil || b.Type == Nil) && (call.Name == "==" || call.Name == "!=") {
+		return AvaVal{
+			Type:  Bool,
+			Value: call.Name == "!=",
+		}
+	}
+
 	if a.Type != b.Type {
-		fmt.Printf("Comparison arguments must be same! Received types %d and %d\n", a.Type, b.Type)
-		os.Exit(1)
+		runtimePanic("Comparison arguments must be same! Received types %d and %d", a.Type, b.Type)
 	}
 
-	if a.Type != Int {
-		fmt.Printf("Custom type comparison is not implemented yet.")
-		os.Exit(1)
+	if a.Type == Struct || a.Type == Enum {
+		if val, ok := i.compareOverloaded(call.Name, a, b); ok {
+			return val
+		}
 	}
 
-	aInt, bInt := 0, 0
-	var ok bool
-	if aInt, ok = a.Value.(int); !ok {
-		fmt.Printf("Could not cast value to int in comparison %s\n", call.Name)
-		os.Exit(1)
+	val := false
+	switch call.Name {
+	case "==":
+		val = reflect.DeepEqual(a.Value, b.Value)
+	case "!=":
+		val = !reflect.DeepEqual(a.Value, b.Value)
+	case "<", ">", "<=", ">=":
+		val = ordered(call.Name, a, b)
+	case "&":
+		val = a.Value.(bool) && b.Value.(bool)
+	case "|":
+		val = a.Value.(bool) || b.Value.(bool)
+	default:
+		runtimePanic("Unsupported comparison operator: %s", call.Name)
 	}
-	if bInt, ok = b.Value.(int); !ok {
-		fmt.Printf("Could not cast value to int in comparison %s\n", call.Name)
-		os.Exit(1)
+
+	return AvaVal{
+		Type:  Bool,
+		Value: val,
+	}
+}
+
+// compareOverloaded compares structs and enums that implement Eq or Ord. Other
+// structs and enums are equal when all their values are.
+func (i *Interp) compareOverloaded(op string, a AvaVal, b AvaVal) (AvaVal, bool) {
+	if _, ok := i.methods[typeNameOf(a)][operatorTraits[op].Method]; !ok {
+		return AvaVal{}, false
 	}
 
 	val := false
-	switch call.Name {
-	case "<":
-		val = aInt < bInt
+	switch op {
 	case "==":
-		val = aInt == bInt
+		val = i.callOperator("eq", a, b).Value.(bool)
+	case "!=":
+		val = !i.callOperator("eq", a, b).Value.(bool)
+	case "<":
+		val = i.callOperator("lt", a, b).Value.(bool)
+	case ">":
+		val = i.callOperator("lt", b, a).Value.(bool)
+	case "<=":
+		val = !i.callOperator("lt", b, a).Value.(bool)
+	case ">=":
+		val = !i.callOperator("lt", a, b).Value.(bool)
 	default:
-		fmt.Printf("Unsupported comparison operator: %s\n", call.Name)
-		os.Exit(1)
+		return AvaVal{}, false
 	}
 
 	return AvaVal{
 		Type:  Bool,
 		Value: val,
+	}, true
+}
+
+// ordered compares two numbers, strings or chars with `<`, `>`, `<=` or `>=`.
+func ordered(op string, a AvaVal, b AvaVal) bool {
+	switch a.Type {
+	case Int:
+		return compareOrdered(a.Value.(int), b.Value.(int), op)
+	case Float:
+		return compareOrdered(a.Value.(float64), b.Value.(float64), op)
+	case String:
+		return compareOrdered(a.Value.(string), b.Value.(string), op)
+	case Char:
+		return compareOrdered(a.Value.(rune), b.Value.(rune), op)
+	}
+
+	runtimePanic("Values of type %s cannot be ordered.", a.Type)
+	return false
+}
+
+func compareOrdered[T int | float64 | string | rune](a T, b T, op string) bool {
+	switch op {
+	case "<":
+		return a < b
+	case ">":
+		return a > b
+	case "<=":
+		return a <= b
 	}
+
+	return a >= b
 }
 
 func (i *Interp) VisitFuncCall(call FuncCall) AvaVal {
@@ -175,170 +485,617 @@ func (i *Interp) VisitFuncCall(call FuncCall) AvaVal {
 		return i.visitComparisonCall(call)
 	}
 
+	// Variables holding a function shadow functions of the same name
+	if v, ok := i.environment.Lookup(call.Name); ok {
+		return i.callValue(v.Value, call.Args, call.Pos)
+	}
+
 	if fun, ok := i.functions[call.Name]; ok {
 		return i.findAndRunDefinedFunction(call, fun)
 	}
 
+	if fun, ok := i.findStaticMethod(call.Name); ok {
+		return i.findAndRunDefinedFunction(call, fun)
+	}
+
+	if enum, variant, ok := i.findVariant(call.Name); ok {
+		args := Map(call.Args, func(arg Expr) AvaVal {
+			return i.Visit(arg)
+		})
+		return i.newVariant(enum, variant, args)
+	}
+
+	// Conversions, e.g. `UserId(5)` or `f64(n)`
+	if typ, ok := i.conversion(call.Name); ok && len(call.Args) == 1 {
+		return i.convert(deref(i.Visit(call.Args[0])), typ)
+	}
+
 	return i.findAndRunBuiltInFunction(call)
 }
 
-func (i *Interp) findAndRunDefinedFunction(call FuncCall, def FunctionDefinition) AvaVal {
-	if len(call.Args) != len(def.Params) {
-		fmt.Printf("Function %s expects %d arguments, but got %d\n", def.Name, len(def.Params), len(call.Args))
-		os.Exit(1)
+// conversion returns the type a call named name converts its argument to.
+func (i *Interp) conversion(name string) (TypeSpec, bool) {
+	if typ, ok := i.types[name]; ok {
+		return typ, true
 	}
 
-	i.environment.EnterBlock()
+	if contains(intTypes, name) || contains(floatTypes, name) {
+		return NamedTypeSpec(name), true
+	}
 
-	for k, param := range def.Params {
-		arg := i.Visit(call.Args[k])
-		v := AvaVar{
-			Type:  arg.Type,
-			Value: arg,
+	return TypeSpec{}, false
+}
+
+// convert changes the representation of numbers, values of other types do
+// not change.
+func (i *Interp) convert(val AvaVal, typ TypeSpec) AvaVal {
+	for typ.Kind == NamedType {
+		next, ok := i.types[typ.Name]
+		if !ok {
+			break
 		}
-		i.environment.DeclareAssign(param.Name, v)
+		typ = next
 	}
 
-	returnValue := i.Visit(def.Body)
+	if typ.Kind != NamedType || len(typ.Elems) > 0 {
+		return val
+	}
 
-	i.environment.ExitBlock()
+	if f, ok := val.Value.(float64); ok && contains(intTypes, typ.Name) {
+		return intVal(int(f))
+	} else if n, ok := val.Value.(int); ok && contains(floatTypes, typ.Name) {
+		return AvaVal{
+			Type:  Float,
+			Value: float64(n),
+		}
+	}
 
-	return returnValue
+	return val
 }
 
-func (i *Interp) findAndRunBuiltInFunction(call FuncCall) AvaVal {
-	builtins := reflect.ValueOf(AvaBuiltins{})
-	m := builtins.MethodByName(call.Name)
+func (i *Interp) VisitCallExpr(call CallExpr) AvaVal {
+	return i.callValue(i.Visit(call.Callee), call.Args, call.Pos)
+}
 
-	if !m.IsValid() {
-		fmt.Printf("Undefined function %s\n", call.Name)
-		os.Exit(1)
+func (i *Interp) callValue(val AvaVal, args []Expr, pos Pos) AvaVal {
+	vals := Map(args, func(arg Expr) AvaVal {
+		return i.Visit(arg)
+	})
+	i.at(pos)
+
+	val = deref(val)
+	if val.Type != Func {
+		runtimePanic("Cannot call value of type %s", val.Type)
 	}
 
+	return i.runFunction(val.Value.(FunctionDefinition), vals)
+}
+
+func (i *Interp) findAndRunDefinedFunction(call FuncCall, def FunctionDefinition) AvaVal {
 	args := Map(call.Args, func(arg Expr) AvaVal {
 		return i.Visit(arg)
 	})
-	//argTypes := Map(args, func(arg AvaVar) reflect.Type {
-	//	return reflect.TypeOf(arg)
-	//})
+	i.at(call.Pos)
 
-	if len(args) != m.Type().NumIn() {
-		fmt.Printf("Function %s expects %d arguments, but received %d.\n", call.Name, len(args), m.Type().NumIn())
-		os.Exit(1)
-	}
+	return i.runFunction(def, i.bindArgs(def, call.Args, args, 0))
+}
 
-	//var x interface{}
-	//interfaceType := reflect.TypeOf(x)
-	//for i, argType := range argTypes {
-	//	if m.Type().In(i) != interfaceType && m.Type().In(i) != argType {
-	//		fmt.Printf("Function %s expected argument %d to be of type %v, but got %v\n", call.Name, i+1, m.Type().String(), argType.String())
-	//		os.Exit(1)
-	//	}
-	//}
+// bindArgs orders the values of the arguments of a call the way the
+// parameters of def are declared. The arguments of a variadic parameter are
+// collected into an array and parameters without an argument get their
+// default value. The first self arguments are the receiver of a method call.
+func (i *Interp) bindArgs(def FunctionDefinition, args []Expr, vals []AvaVal, self int) []AvaVal {
+	slots, err := bindArgs(def.Name, def.Params[self:], args[self:])
+	if err != nil {
+		runtimePanic("%s", err)
+	}
 
-	argValues := Map(args, func(arg AvaVal) reflect.Value {
-		return reflect.ValueOf(arg.Value)
-	})
+	params := make([]AvaVal, len(def.Params))
+	bound := make([]bool, len(def.Params))
+	copy(params, vals[:self])
 
-	returnType := Void
-	if m.Type().NumOut() > 0 {
-		t := m.Type().Out(0).String()
-		switch t {
-		case "int":
-			returnType = Int
-		case "string":
-			returnType = String
-		default:
-			fmt.Printf("Returning type %s from a builtin function is not supported yet.")
-			os.Exit(1)
+	for k, param := range def.Params {
+		if param.IsVariadic {
+			params[k] = AvaVal{
+				Type:  Array,
+				Value: make([]AvaVal, 0),
+			}
 		}
 	}
 
-	// TODO: Returning a reflect.Value could lead to a problem.
-	// It is not a pure type anymore. Deal with it later.
-	var result reflect.Value
-	results := m.Call(argValues)
-	if len(results) > 0 {
-		result = results[0]
+	for k, slot := range slots {
+		slot += self
+		if def.Params[slot].IsVariadic {
+			params[slot].Value = append(params[slot].Value.([]AvaVal), vals[self+k])
+		} else {
+			params[slot] = vals[self+k]
+		}
+		bound[slot] = true
 	}
-	return AvaVal{
-		Type:  returnType,
-		Value: result,
+
+	// Defaults are evaluated in the scope the function is declared in
+	for k, param := range def.Params {
+		if k < self || bound[k] || param.Default == nil {
+			continue
+		}
+
+		env := i.environment
+		i.environment = def.Env
+		params[k] = i.Visit(param.Default)
+		i.environment = env
 	}
+
+	return params
 }
 
-func (i *Interp) VisitFuncDecl(decl FuncDecl) AvaVal {
-	def := FunctionDefinition{
-		Name:   decl.Name,
-		Params: decl.Params,
-		Body:   decl.Body,
+func (i *Interp) runFunction(def FunctionDefinition, args []AvaVal) AvaVal {
+	if len(args) != len(def.Params) {
+		runtimePanic("Function %s expects %d arguments, but got %d", def.Name, len(def.Params), len(args))
 	}
-	i.functions[decl.Name] = def
-	return AvaVal{
+
+	// The body sees the variables where the function was defined, not the
+	// ones of the caller.
+	caller := i.environment
+	i.environment = def.Env.Capture()
+	i.environment.EnterBlock()
+
+	// A panic leaves the function too
+	i.callStack = append(i.callStack, stackFrame{
+		Function: def.Name,
+	})
+	defer func() {
+		i.environment = caller
+		i.callStack = i.callStack[:len(i.callStack)-1]
+	}()
+
+	for k, param := range def.Params {
+		arg := args[k]
+		v := &AvaVar{
+			Type:  arg.Type,
+			IsRef: arg.Type == Ref,
+			Value: arg,
+		}
+		i.environment.DeclareAssign(param.Name, v)
+	}
+
+	i.runBody(def.Body)
+
+	returnValue := AvaVal{
 		Type: Void,
 	}
+	if i.returnValue != nil {
+		returnValue = *i.returnValue
+		i.returnValue = nil
+	}
+
+	return returnValue
 }
 
-func (i *Interp) inferType(val AvaVal) (typ AvaType) {
-	typeName := reflect.TypeOf(val.Value).String()
+func (i *Interp) runBody(body Block) {
+	defer func() {
+		r := recover()
+		if ret, ok := r.(earlyReturn); ok {
+			i.returnValue = &ret.value
+			r = nil
+		} else if err, ok := r.(RuntimeError); ok && err.Trace == nil {
+			// The stack is traced where the panic starts
+			err.Trace = append([]stackFrame{}, i.callStack...)
+			r = err
+		}
 
-	switch typeName {
-	case "int":
-		typ = Int
-	}
+		// Deferred expressions run however the function ends
+		if p := i.runDeferred(); p != nil {
+			r = p
+		}
 
-	return
+		if r != nil {
+			i.returnValue = nil
+			panic(r)
+		}
+	}()
+
+	i.Visit(body)
 }
 
-func (i *Interp) VisitConstDecl(decl ConstDecl) AvaVal {
-	val := i.Visit(decl.Init)
+// runDeferred runs the expressions deferred by the running function, the
+// last one first. A panicking expression does not stop the ones deferred
+// before it, the last panic is returned to be raised again.
+func (i *Interp) runDeferred() (panicked any) {
+	returnValue := i.returnValue
+	i.returnValue = nil
 
-	// TODO: This logic could cause issues
-	typeName := decl.Type
-	typ := val.Type
-	if typeName == "" && typ == Unknown {
-		typ = i.inferType(val)
-	}
-	// TODO: ref
+	for {
+		frame := &i.callStack[len(i.callStack)-1]
+		if len(frame.Deferred) == 0 {
+			break
+		}
 
-	if typ != val.Type {
-		fmt.Printf("Constant variable %s declared with type %s, but got expression with type %d\n", decl.Name, decl.Type, typ)
-		os.Exit(1)
-	}
+		deferred := frame.Deferred[len(frame.Deferred)-1]
+		frame.Deferred = frame.Deferred[:len(frame.Deferred)-1]
 
-	v := AvaVar{
-		Type:    typ,
-		Value:   val,
-		IsConst: true,
-		//IsRef:   isRef,
+		if r := i.runDeferredExpr(deferred); r != nil {
+			panicked = r
+		}
 	}
 
-	i.environment.DeclareAssign(decl.Name, v)
-
-	return val
+	i.returnValue = returnValue
+	return panicked
 }
 
-func (i *Interp) VisitVarDecl(decl VarDecl) AvaVal {
-	val := i.Visit(decl.Init)
+func (i *Interp) runDeferredExpr(deferred deferredExpr) (panicked any) {
+	env := i.environment
+	defer func() {
+		i.environment = env
+		panicked = recover()
+		if err, ok := panicked.(RuntimeError); ok && err.Trace == nil {
+			err.Trace = append([]stackFrame{}, i.callStack...)
+			panicked = err
+		}
+	}()
 
-	// TODO: This logic could cause issues
-	typeName := decl.Type
-	typ := val.Type
-	if typeName == "" && typ == Unknown {
-		typ = i.inferType(val)
-	}
-	// TODO: ref
+	i.environment = deferred.Env
+	i.Visit(deferred.Expr)
+
+	return nil
+}
+
+// findStaticMethod resolves a qualified name like `Vec2::new` to a method.
+func (i *Interp) findStaticMethod(name string) (FunctionDefinition, bool) {
+	typeName, methodName, ok := strings.Cut(name, "::")
+	if !ok {
+		return FunctionDefinition{}, false
+	}
+
+	def, ok := i.methods[typeName][methodName]
+	return def, ok
+}
+
+func (i *Interp) VisitMethodCall(call MethodCall) AvaVal {
+	ref := i.follow(i.reference(call.Receiver))
+	recv := ref.Get()
+
+	if call.Optional && recv.Type == Nil {
+		return recv
+	}
+
+	def, ok := i.methods[typeNameOf(recv)][call.Method]
+	if field, found := i.funcField(recv, call.Method); !ok && found {
+		return i.callValue(field, call.Args, call.Pos)
+	} else if !ok {
+		if val, found := i.builtinOperator(call, recv); found {
+			return val
+		}
+		runtimePanic("Value of type %s has no method %s", recv.Type, call.Method)
+	}
+
+	// Methods taking `&self` get a reference to the receiver, so they can change it.
+	// Constants cannot be changed, so their methods work on a copy.
+	self := recv
+	if def.Params[0].Type.IsRef {
+		if ref.Var.IsConst {
+			ref = AvaRef{
+				Var: &AvaVar{
+					Type:  recv.Type,
+					Value: recv,
+				},
+			}
+		}
+
+		self = AvaVal{
+			Type:  Ref,
+			Value: ref,
+		}
+	}
+
+	args := []AvaVal{self}
+	for _, arg := range call.Args {
+		args = append(args, i.Visit(arg))
+	}
+	i.at(call.Pos)
+
+	return i.runFunction(def, i.bindArgs(def, append([]Expr{call.Receiver}, call.Args...), args, 1))
+}
+
+// operatorMethods maps the methods of the arithmetic operator traits to their
+// operators.
+var operatorMethods = map[string]string{
+	"add": "+",
+	"sub": "-",
+	"mul": "*",
+	"div": "/",
+	"rem": "%",
+}
+
+// builtinOperator calls an operator trait method like `add` or `lt` on a
+// number, char, string or bool, which implement those traits with their
+// built-in operators.
+func (i *Interp) builtinOperator(call MethodCall, recv AvaVal) (AvaVal, bool) {
+	if !contains([]AvaType{Int, Float, Char, String, Bool}, recv.Type) {
+		return AvaVal{}, false
+	}
+
+	args := Map(call.Args, func(arg Expr) AvaVal {
+		return deref(i.Visit(arg))
+	})
+	i.at(call.Pos)
+
+	if op, ok := operatorMethods[call.Method]; ok && len(args) == 1 {
+		return i.arithmetic(op, recv, args[0]), true
+	}
+
+	switch {
+	case call.Method == "neg" && len(args) == 0:
+		return i.negate(recv), true
+	case call.Method == "eq" && len(args) == 1:
+		return AvaVal{
+			Type:  Bool,
+			Value: reflect.DeepEqual(recv.Value, args[0].Value),
+		}, true
+	case call.Method == "lt" && len(args) == 1:
+		return AvaVal{
+			Type:  Bool,
+			Value: ordered("<", recv, args[0]),
+		}, true
+	}
+
+	return AvaVal{}, false
+}
+
+// typeNameOf returns the name of the struct or enum of val, which its methods
+// are found by.
+func typeNameOf(val AvaVal) string {
+	switch val.Type {
+	case Struct:
+		return val.Value.(AvaStruct).Name
+	case Enum:
+		return val.Value.(AvaEnum).Enum
+	}
+
+	return ""
+}
+
+// callOperator calls the method overloading an operator for the struct or
+// enum recv, like `add` for `+`.
+func (i *Interp) callOperator(method string, recv AvaVal, args ...AvaVal) AvaVal {
+	def, ok := i.methods[typeNameOf(recv)][method]
+	if !ok {
+		runtimePanic("Value of type %s has no method %s", recv.Type, method)
+	}
+
+	// The receiver is a temporary value, so `&self` gets a reference to a copy.
+	self := recv
+	if def.Params[0].Type.IsRef {
+		self = AvaVal{
+			Type: Ref,
+			Value: AvaRef{
+				Var: &AvaVar{
+					Type:  recv.Type,
+					Value: recv,
+				},
+			},
+		}
+	}
+
+	return i.runFunction(def, append([]AvaVal{self}, args...))
+}
+
+// funcField finds a field holding a function, which is called like a method.
+func (i *Interp) funcField(recv AvaVal, name string) (AvaVal, bool) {
+	if recv.Type != Struct {
+		return AvaVal{}, false
+	}
+
+	return recv.Value.(AvaStruct).Get(name)
+}
+
+func (i *Interp) findAndRunBuiltInFunction(call FuncCall) AvaVal {
+	m, ok := builtinFunc(call.Name)
+	if !ok {
+		runtimePanic("Undefined function %s", call.Name)
+	}
+
+	args := Map(call.Args, func(arg Expr) AvaVal {
+		return deref(i.Visit(arg))
+	})
+	i.at(call.Pos)
+	//argTypes := Map(args, func(arg AvaVar) reflect.Type {
+	//	return reflect.TypeOf(arg)
+	//})
+
+	if m.Type().IsVariadic() {
+		if len(args) < m.Type().NumIn()-1 {
+			runtimePanic("Function %s expects at least %d arguments, but received %d.", call.Name, m.Type().NumIn()-1, len(args))
+		}
+	} else if len(args) != m.Type().NumIn() {
+		runtimePanic("Function %s expects %d arguments, but received %d.", call.Name, m.Type().NumIn(), len(args))
+	}
+
+	//var x interface{}
+	//interfaceType := reflect.TypeOf(x)
+	//for i, argType := range argTypes {
+	//	if m.Type().In(i) != interfaceType && m.Type().In(i) != argType {
+	//		fmt.Printf("Function %s expected argument %d to be of type %v, but got %v\n", call.Name, i+1, m.Type().String(), argType.String())
+	//		os.Exit(1)
+	//	}
+	//}
+
+	argValues := make([]reflect.Value, len(args))
+	for k, arg := range args {
+		argValues[k] = toGo(arg, builtinParam(m.Type(), k))
+	}
+
+	results := m.Call(argValues)
+	if len(results) == 0 {
+		return AvaVal{
+			Type: Void,
+		}
+	}
+
+	// A Go error is returned as `Result::Err`
+	if len(results) == 2 {
+		if err, _ := results[1].Interface().(error); err != nil {
+			return newResult("Err", newError(err.Error()))
+		}
+		return newResult("Ok", fromGo(results[0]))
+	}
+
+	return fromGo(results[0])
+}
+
+// fromGo converts a value returned by a builtin to an Ava value.
+func fromGo(val reflect.Value) AvaVal {
+	switch val.Kind() {
+	case reflect.Int:
+		return intVal(int(val.Int()))
+	case reflect.String:
+		return AvaVal{
+			Type:  String,
+			Value: val.String(),
+		}
+	case reflect.Bool:
+		return AvaVal{
+			Type:  Bool,
+			Value: val.Bool(),
+		}
+	case reflect.Float64:
+		return AvaVal{
+			Type:  Float,
+			Value: val.Float(),
+		}
+	case reflect.Int32:
+		return AvaVal{
+			Type:  Char,
+			Value: rune(val.Int()),
+		}
+	case reflect.Slice:
+		elems := make([]AvaVal, val.Len())
+		for k := range elems {
+			elems[k] = fromGo(val.Index(k))
+		}
+
+		return AvaVal{
+			Type:  Array,
+			Value: elems,
+		}
+	case reflect.Pointer:
+		if val.IsNil() {
+			return AvaVal{
+				Type: Nil,
+			}
+		}
+
+		return fromGo(val.Elem())
+	}
+
+	runtimePanic("Returning type %s from a builtin function is not supported yet.", val.Type())
+	return AvaVal{}
+}
+
+// toGo converts an argument of a builtin to the Go type of its parameter.
+// Parameters taking any value get the AvaVal, so nil and other Ava values are
+// formatted the way Ava prints them.
+func toGo(arg AvaVal, typ reflect.Type) reflect.Value {
+	switch typ.Kind() {
+	case reflect.Interface:
+		return reflect.ValueOf(arg)
+	case reflect.Slice:
+		elems := arg.Value.([]AvaVal)
+		slice := reflect.MakeSlice(typ, len(elems), len(elems))
+		for k, elem := range elems {
+			slice.Index(k).Set(toGo(deref(elem), typ.Elem()))
+		}
+
+		return slice
+	}
+
+	return reflect.ValueOf(arg.Value)
+}
+
+// builtinParam returns the type of the k-th argument of a builtin, which may be
+// part of its variadic parameter.
+func builtinParam(fn reflect.Type, k int) reflect.Type {
+	if fn.IsVariadic() && k >= fn.NumIn()-1 {
+		return fn.In(fn.NumIn() - 1).Elem()
+	}
+
+	return fn.In(k)
+}
+
+func (i *Interp) VisitFuncDecl(decl FuncDecl) AvaVal {
+	def := FunctionDefinition{
+		Name:   decl.Name,
+		Params: decl.Params,
+		Body:   decl.Body,
+		Env:    i.environment.Capture(),
+	}
+	i.functions[decl.Name] = def
+	return AvaVal{
+		Type: Void,
+	}
+}
+
+func (i *Interp) inferType(val AvaVal) (typ AvaType) {
+	typeName := reflect.TypeOf(val.Value).String()
+
+	switch typeName {
+	case "int":
+		typ = Int
+	}
+
+	return
+}
+
+func (i *Interp) VisitConstDecl(decl ConstDecl) AvaVal {
+	// The checker has folded the initializer already
+	var val AvaVal
+	if decl.Value != nil && decl.Value.Type != Zero {
+		val = cloneVal(*decl.Value)
+	} else {
+		val = i.Visit(decl.Init)
+	}
+
+	// TODO: This logic could cause issues
+	typeName := decl.Type.String()
+	typ := val.Type
+	if typeName == "" && typ == Unknown {
+		typ = i.inferType(val)
+	}
 
 	if typ != val.Type {
-		fmt.Printf("Variable %s declared with type %s, but got expression with type %d\n", decl.Name, decl.Type, typ)
-		os.Exit(1)
+		runtimePanic("Constant variable %s declared with type %s, but got expression with type %d", decl.Name, decl.Type, typ)
 	}
 
-	v := AvaVar{
+	v := &AvaVar{
+		Type:    typ,
+		Value:   val,
+		IsConst: true,
+		IsRef:   typ == Ref,
+	}
+
+	i.environment.DeclareAssign(decl.Name, v)
+
+	return val
+}
+
+func (i *Interp) VisitVarDecl(decl VarDecl) AvaVal {
+	val := i.Visit(decl.Init)
+
+	// TODO: This logic could cause issues
+	typeName := decl.Type.String()
+	typ := val.Type
+	if typeName == "" && typ == Unknown {
+		typ = i.inferType(val)
+	}
+
+	if typ != val.Type {
+		runtimePanic("Variable %s declared with type %s, but got expression with type %d", decl.Name, decl.Type, typ)
+	}
+
+	v := &AvaVar{
 		Type:    typ,
 		Value:   val,
 		IsConst: false,
-		//IsRef:   isRef,
+		IsRef:   typ == Ref,
 	}
 
 	i.environment.DeclareAssign(decl.Name, v)
@@ -347,11 +1104,356 @@ func (i *Interp) VisitVarDecl(decl VarDecl) AvaVal {
 	}
 }
 
+func (i *Interp) VisitTupleVarDecl(decl TupleVarDecl) AvaVal {
+	val := i.Visit(decl.Init)
+
+	if val.Type != Tuple {
+		runtimePanic("Cannot destructure value of type %s into %d variables", val.Type, len(decl.Names))
+	}
+
+	elems := val.Value.(AvaTuple)
+	if len(elems) != len(decl.Names) {
+		runtimePanic("Cannot destructure tuple of %d elements into %d variables", len(elems), len(decl.Names))
+	}
+
+	for k, name := range decl.Names {
+		v := &AvaVar{
+			Type:  elems[k].Type,
+			IsRef: elems[k].Type == Ref,
+			Value: elems[k],
+		}
+		i.environment.DeclareAssign(name, v)
+	}
+
+	return AvaVal{
+		Type: Void,
+	}
+}
+
 func (i *Interp) VisitVariable(variable Variable) AvaVal {
+	if enum, variant, ok := i.findVariant(variable.Name); ok {
+		return i.newVariant(enum, variant, []AvaVal{})
+	}
+
+	if v, ok := i.environment.Lookup(variable.Name); ok {
+		return v.Value
+	}
+
+	// Functions can be used as values
+	def, ok := i.functions[variable.Name]
+	if !ok {
+		def, ok = i.findStaticMethod(variable.Name)
+	}
+	if ok {
+		return AvaVal{
+			Type:  Func,
+			Value: def,
+		}
+	}
+
 	v := i.environment.Get(variable.Name)
 	return v.Value
 }
 
+// findVariant resolves a qualified name like `Shape::Circle` to an enum variant.
+func (i *Interp) findVariant(name string) (EnumDefinition, EnumVariant, bool) {
+	enumName, variantName, ok := strings.Cut(name, "::")
+	if !ok {
+		return EnumDefinition{}, EnumVariant{}, false
+	}
+
+	enum, ok := i.enums[enumName]
+	if !ok {
+		return EnumDefinition{}, EnumVariant{}, false
+	}
+
+	variant, ok := enum.Variant(variantName)
+	if !ok {
+		runtimePanic("Enum %s has no variant %s", enumName, variantName)
+	}
+
+	return enum, variant, true
+}
+
+func (i *Interp) newVariant(enum EnumDefinition, variant EnumVariant, args []AvaVal) AvaVal {
+	if len(args) != len(variant.Fields) {
+		runtimePanic("Variant %s::%s expects %d values, but got %d", enum.Name, variant.Name, len(variant.Fields), len(args))
+	}
+
+	return AvaVal{
+		Type: Enum,
+		Value: AvaEnum{
+			Enum:    enum.Name,
+			Variant: variant.Name,
+			Fields:  args,
+		},
+	}
+}
+
+func (i *Interp) VisitIndexExpr(expr IndexExpr) AvaVal {
+	val := deref(i.Visit(expr.Expr))
+	index := i.Visit(expr.Index)
+	i.at(expr.Pos)
+
+	switch val.Type {
+	case Array:
+		elems := val.Value.([]AvaVal)
+
+		if index.Type == Range {
+			r := index.Value.(AvaRange)
+			if r.Start < 0 || r.Last() >= len(elems) || r.Start > r.Last()+1 {
+				runtimePanic("Slice bounds %s out of range for array of length %d", r, len(elems))
+			}
+
+			return AvaVal{
+				Type:  Array,
+				Value: elems[r.Start : r.Last()+1],
+			}
+		}
+
+		i.checkIndex(val, index)
+		return elems[index.Value.(int)]
+	case Dict:
+		m := val.Value.(*AvaMap)
+
+		elem, ok := m.Get(index)
+		if !ok {
+			runtimePanic("Key %v not found in map", index)
+		}
+
+		return elem
+	case String:
+		runes := []rune(val.Value.(string))
+
+		if index.Type == Range {
+			r := index.Value.(AvaRange)
+			if r.Start < 0 || r.Last() >= len(runes) || r.Start > r.Last()+1 {
+				runtimePanic("Slice bounds %s out of range for string of %d characters", r, len(runes))
+			}
+
+			return AvaVal{
+				Type:  String,
+				Value: string(runes[r.Start : r.Last()+1]),
+			}
+		}
+
+		k := index.Value.(int)
+		if k < 0 || k >= len(runes) {
+			runtimePanic("Index %d out of range for string of %d characters", k, len(runes))
+		}
+
+		return AvaVal{
+			Type:  Char,
+			Value: runes[k],
+		}
+	}
+
+	runtimePanic("Cannot index value of type %s", val.Type)
+	return AvaVal{}
+}
+
+func (i *Interp) checkIndex(array AvaVal, index AvaVal) {
+	if index.Type != Int {
+		runtimePanic("Array index must be int, but got %s", index.Type)
+	}
+
+	elems := array.Value.([]AvaVal)
+	if k := index.Value.(int); k < 0 || k >= len(elems) {
+		runtimePanic("Index %d out of range for array of length %d", k, len(elems))
+	}
+}
+
+func (i *Interp) VisitFieldExpr(expr FieldExpr) AvaVal {
+	val := deref(i.Visit(expr.Expr))
+	i.at(expr.Pos)
+
+	if expr.Optional && val.Type == Nil {
+		return val
+	}
+
+	if val.Type == Tuple {
+		elems := val.Value.(AvaTuple)
+
+		k, err := strconv.Atoi(expr.Field)
+		if err != nil || k < 0 || k >= len(elems) {
+			runtimePanic("Tuple of %d elements has no field %s", len(elems), expr.Field)
+		}
+
+		return elems[k]
+	}
+
+	if val.Type == Struct {
+		s := val.Value.(AvaStruct)
+
+		field, ok := s.Get(expr.Field)
+		if !ok {
+			runtimePanic("Struct %s has no field %s", s.Name, expr.Field)
+		}
+
+		return field
+	}
+
+	runtimePanic("Value of type %s has no field %s", val.Type, expr.Field)
+	return AvaVal{}
+}
+
+func (i *Interp) VisitCoalesceExpr(expr CoalesceExpr) AvaVal {
+	val := i.Visit(expr.Left)
+	if val.Type != Nil {
+		return val
+	}
+
+	return i.Visit(expr.Right)
+}
+
+func (i *Interp) VisitTryExpr(expr TryExpr) AvaVal {
+	val := deref(i.Visit(expr.Expr))
+
+	result := val.Value.(AvaEnum)
+	if result.Variant == "Err" {
+		panic(earlyReturn{
+			value: val,
+		})
+	}
+
+	return result.Fields[0]
+}
+
+func (i *Interp) VisitRecoverExpr(expr RecoverExpr) (result AvaVal) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		err, ok := r.(RuntimeError)
+		if !ok {
+			panic(r)
+		}
+
+		result = newResult("Err", newError(err.Message))
+	}()
+
+	return newResult("Ok", i.Visit(expr.Call))
+}
+
+func (i *Interp) VisitRefExpr(expr RefExpr) AvaVal {
+	return AvaVal{
+		Type:  Ref,
+		Value: i.reference(expr.Expr),
+	}
+}
+
+func (i *Interp) VisitMatchExpr(expr MatchExpr) AvaVal {
+	val := deref(i.Visit(expr.Subject))
+
+	for _, arm := range expr.Arms {
+		// Every arm gets its own scope for the variables bound by its pattern.
+		i.environment.EnterBlock()
+
+		if !i.matchPattern(arm.Pattern, val) {
+			i.environment.ExitBlock()
+			continue
+		}
+
+		if arm.Guard != nil {
+			guard := i.Visit(arm.Guard)
+			if guard.Type != Bool {
+				runtimePanic("Match guard must be bool, but got %s", guard.Type)
+			}
+
+			if !guard.Value.(bool) {
+				i.environment.ExitBlock()
+				continue
+			}
+		}
+
+		result := AvaVal{
+			Type: Void,
+		}
+		if body, ok := arm.Body.(Expr); ok {
+			result = i.Visit(body)
+		} else {
+			i.Visit(arm.Body)
+		}
+
+		i.environment.ExitBlock()
+		return result
+	}
+
+	runtimePanic("No match arm matches value %v", val)
+	return AvaVal{}
+}
+
+// matchPattern reports whether the value matches the pattern and declares the
+// variables bound by the pattern in the current scope.
+func (i *Interp) matchPattern(pattern Pattern, val AvaVal) bool {
+	switch p := pattern.(type) {
+	case WildcardPattern:
+		return true
+	case BindingPattern:
+		i.environment.DeclareAssign(p.Name, &AvaVar{
+			Type:  val.Type,
+			Value: val,
+		})
+		return true
+	case LiteralPattern:
+		lit := i.Visit(p.Value)
+		return lit.Type == val.Type && lit.Value == val.Value
+	case TuplePattern:
+		if val.Type != Tuple {
+			return false
+		}
+
+		return i.matchPatterns(p.Elems, val.Value.(AvaTuple))
+	case VariantPattern:
+		if val.Type != Enum {
+			return false
+		}
+
+		e := val.Value.(AvaEnum)
+		if e.Enum != p.Enum || e.Variant != p.Variant {
+			return false
+		}
+
+		return i.matchPatterns(p.Fields, e.Fields)
+	}
+
+	return false
+}
+
+func (i *Interp) matchPatterns(patterns []Pattern, vals []AvaVal) bool {
+	if len(patterns) != len(vals) {
+		return false
+	}
+
+	for k, pattern := range patterns {
+		if !i.matchPattern(pattern, vals[k]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (i *Interp) VisitRangeExpr(expr RangeExpr) AvaVal {
+	start := i.Visit(expr.Start)
+	end := i.Visit(expr.End)
+
+	if start.Type != Int || end.Type != Int {
+		runtimePanic("Range bounds must be int, but got %s and %s", start.Type, end.Type)
+	}
+
+	return AvaVal{
+		Type: Range,
+		Value: AvaRange{
+			Start:     start.Value.(int),
+			End:       end.Value.(int),
+			Inclusive: expr.Inclusive,
+		},
+	}
+}
+
 func (i *Interp) VisitIntLit(lit IntLit) AvaVal {
 	return AvaVal{
 		Type:  Int,
@@ -380,10 +1482,121 @@ func (i *Interp) VisitStrLit(lit StrLit) AvaVal {
 	}
 }
 
+func (i *Interp) VisitCharLit(lit CharLit) AvaVal {
+	return AvaVal{
+		Type:  Char,
+		Value: lit.Value,
+	}
+}
+
+// VisitInterpStr formats each part the way Print shows it.
+func (i *Interp) VisitInterpStr(s InterpStr) AvaVal {
+	sb := strings.Builder{}
+	for _, part := range s.Parts {
+		sb.WriteString(deref(i.Visit(part)).String())
+	}
+
+	return AvaVal{
+		Type:  String,
+		Value: sb.String(),
+	}
+}
+
+func (i *Interp) VisitFuncLit(lit FuncLit) AvaVal {
+	return AvaVal{
+		Type: Func,
+		Value: FunctionDefinition{
+			Name:   "lambda",
+			Params: lit.Params,
+			Body:   lit.Body,
+			Env:    i.environment.Capture(),
+		},
+	}
+}
+
+func (i *Interp) VisitNilLit(_ NilLit) AvaVal {
+	return AvaVal{
+		Type: Nil,
+	}
+}
+
+func (i *Interp) VisitArrayLit(lit ArrayLit) AvaVal {
+	elems := Map(lit.Elems, func(elem Expr) AvaVal {
+		return i.Visit(elem)
+	})
+
+	return AvaVal{
+		Type:  Array,
+		Value: elems,
+	}
+}
+
+func (i *Interp) VisitMapLit(lit MapLit) AvaVal {
+	m := NewAvaMap()
+
+	for k, key := range lit.Keys {
+		m.Set(i.Visit(key), i.Visit(lit.Values[k]))
+	}
+
+	return AvaVal{
+		Type:  Dict,
+		Value: m,
+	}
+}
+
+func (i *Interp) VisitTupleLit(lit TupleLit) AvaVal {
+	elems := Map(lit.Elems, func(elem Expr) AvaVal {
+		return i.Visit(elem)
+	})
+
+	return AvaVal{
+		Type:  Tuple,
+		Value: AvaTuple(elems),
+	}
+}
+
+func (i *Interp) VisitStructLit(lit StructLit) AvaVal {
+	def, ok := i.structs[lit.Name]
+	if !ok {
+		runtimePanic("Undefined struct %s", lit.Name)
+	}
+
+	values := make(map[string]AvaVal)
+	for _, field := range lit.Fields {
+		values[field.Name] = i.Visit(field.Value)
+	}
+
+	// Fields are stored in declaration order, whatever order the literal uses.
+	fields := make([]AvaField, len(def.Fields))
+	for k, field := range def.Fields {
+		val, ok := values[field.Name]
+		if !ok {
+			runtimePanic("Missing field %s in literal of struct %s", field.Name, def.Name)
+		}
+
+		fields[k] = AvaField{
+			Name:  field.Name,
+			Value: val,
+		}
+	}
+
+	return AvaVal{
+		Type: Struct,
+		Value: AvaStruct{
+			Name:   def.Name,
+			Fields: fields,
+		},
+	}
+}
+
 func (i *Interp) VisitBlock(block Block) AvaVal {
 	i.environment.EnterBlock()
 	for _, stmt := range block.Stmts {
 		i.Visit(stmt)
+
+		if i.returnValue != nil {
+			break
+		}
 	}
 	i.environment.ExitBlock()
 	return AvaVal{}
@@ -414,8 +1627,7 @@ func (i *Interp) VisitWhileStmt(stmt WhileStmt) AvaVal {
 		cond := i.Visit(stmt.Condition)
 
 		if cond.Type != Bool {
-			fmt.Printf("Condition must be bool")
-			os.Exit(1)
+			runtimePanic("Condition must be bool")
 		}
 		val := cond.Value.(bool)
 
@@ -424,34 +1636,278 @@ func (i *Interp) VisitWhileStmt(stmt WhileStmt) AvaVal {
 		}
 
 		i.Visit(stmt.Body)
+
+		if i.returnValue != nil {
+			break
+		}
 	}
 
 	return AvaVal{}
 }
 
+func (i *Interp) VisitForStmt(stmt ForStmt) AvaVal {
+	iterable := deref(i.Visit(stmt.Iterable))
+
+	// The iterable is evaluated once, so changes to it in the body do not affect the loop.
+	switch iterable.Type {
+	case Array:
+		for k, elem := range iterable.Value.([]AvaVal) {
+			if !i.runForIteration(stmt, intVal(k), elem) {
+				break
+			}
+		}
+	case Dict:
+		m := iterable.Value.(*AvaMap)
+		for _, key := range m.Keys {
+			// A single loop variable receives the key, like in `for key in m`.
+			value, _ := m.Get(key)
+			if len(stmt.Key) == 0 {
+				value = key
+			}
+
+			if !i.runForIteration(stmt, key, value) {
+				break
+			}
+		}
+	case String:
+		k := 0
+		for _, r := range iterable.Value.(string) {
+			ch := AvaVal{
+				Type:  Char,
+				Value: r,
+			}
+			if !i.runForIteration(stmt, intVal(k), ch) {
+				break
+			}
+			k++
+		}
+	case Range:
+		r := iterable.Value.(AvaRange)
+		for n := r.Start; n <= r.Last(); n++ {
+			if !i.runForIteration(stmt, intVal(n-r.Start), intVal(n)) {
+				break
+			}
+		}
+	default:
+		runtimePanic("Cannot iterate over value of type %s", iterable.Type)
+	}
+
+	return AvaVal{}
+}
+
+// runForIteration runs the loop body once with the loop variables declared in a fresh scope.
+// It reports whether the loop should keep going.
+func (i *Interp) runForIteration(stmt ForStmt, key AvaVal, value AvaVal) bool {
+	i.environment.EnterBlock()
+
+	if len(stmt.Key) > 0 {
+		i.environment.DeclareAssign(stmt.Key, &AvaVar{
+			Type:  key.Type,
+			Value: key,
+		})
+	}
+	i.environment.DeclareAssign(stmt.Value, &AvaVar{
+		Type:  value.Type,
+		Value: value,
+	})
+
+	i.Visit(stmt.Body)
+
+	i.environment.ExitBlock()
+
+	return i.returnValue == nil
+}
+
+func intVal(n int) AvaVal {
+	return AvaVal{
+		Type:  Int,
+		Value: n,
+	}
+}
+
 func (i *Interp) VisitAssignStmt(stmt AssignStmt) AvaVal {
-	// TODO: If not found??
+	if stmt.Op != "" {
+		return i.compoundAssign(stmt)
+	}
+
+	val := i.Visit(stmt.Value)
+	i.at(stmt.Pos)
+
+	target, ok := stmt.Target.(Variable)
+	if !ok {
+		// Fields and elements are written through a reference to them.
+		i.reference(stmt.Target).Set(val)
+
+		return AvaVal{
+			Type: Void,
+		}
+	}
 
-	variable := i.environment.Get(stmt.Variable)
+	variable := i.environment.Get(target.Name)
 	if variable.Type == Zero {
-		fmt.Printf("Variable %s is not declared.\n", stmt.Variable)
-		os.Exit(1)
+		runtimePanic("Variable %s is not declared.", target.Name)
 	}
 
 	if variable.IsConst {
-		fmt.Printf("Assignment to constant variable %s\n", stmt.Variable)
-		os.Exit(1)
+		runtimePanic("Assignment to constant variable %s", target.Name)
 	}
 
-	val := i.Visit(stmt.Value)
-
-	if variable.Type != val.Type {
-		fmt.Printf("Trying to assign invalid typed value to variable %s\n", stmt.Variable)
+	if variable.Type != val.Type && variable.Type != Nil && val.Type != Nil {
+		fmt.Printf("Trying to assign invalid typed value to variable %s\n", target.Name)
 	}
 
 	variable.Type = val.Type
+	variable.IsRef = val.Type == Ref
 	variable.Value = val
-	i.environment.Assign(stmt.Variable, variable)
+
+	return AvaVal{
+		Type: Void,
+	}
+}
+
+// compoundAssign runs `a += b`. The target is evaluated once, so `a[f()] += 1`
+// calls f once.
+func (i *Interp) compoundAssign(stmt AssignStmt) AvaVal {
+	if target, ok := stmt.Target.(Variable); ok {
+		if variable := i.environment.Get(target.Name); variable.Type == Zero {
+			runtimePanic("Variable %s is not declared.", target.Name)
+		} else if variable.IsConst {
+			runtimePanic("Assignment to constant variable %s", target.Name)
+		}
+	}
+
+	ref := i.reference(stmt.Target)
+	val := deref(i.Visit(stmt.Value))
+	i.at(stmt.Pos)
+
+	ref.Set(i.arithmetic(stmt.Op, deref(ref.Get()), val))
+
+	return AvaVal{
+		Type: Void,
+	}
+}
+
+// reference returns a reference to the place an expression names, like a
+// variable or a field of it. References are followed, so a reference to
+// `r.x` points into the value r refers to. Other values are put in a new
+// variable of their own.
+func (i *Interp) reference(expr Expr) AvaRef {
+	switch e := expr.(type) {
+	case ParenExpr:
+		return i.reference(e.Expr)
+	case Variable:
+		if !strings.Contains(e.Name, "::") {
+			return AvaRef{
+				Var: i.environment.Get(e.Name),
+			}
+		}
+	case DerefExpr:
+		val := i.Visit(e.Expr)
+		i.at(e.Pos)
+		return i.derefRef(val)
+	case FieldExpr:
+		if !e.Optional {
+			base := i.follow(i.reference(e.Expr))
+			i.at(e.Pos)
+			return base.Field(e.Field)
+		}
+	case IndexExpr:
+		base := i.follow(i.reference(e.Expr))
+		if index := i.Visit(e.Index); index.Type != Range {
+			i.at(e.Pos)
+			if base.Get().Type == Array {
+				i.checkIndex(base.Get(), index)
+			}
+			return base.Field(index)
+		}
+	}
+
+	val := i.Visit(expr)
+	return AvaRef{
+		Var: &AvaVar{
+			Type:  val.Type,
+			Value: val,
+		},
+	}
+}
+
+// follow returns the reference stored at r when r holds one.
+func (i *Interp) follow(r AvaRef) AvaRef {
+	if val := r.Get(); val.Type == Ref {
+		return val.Value.(AvaRef)
+	}
+
+	return r
+}
+
+func (i *Interp) derefRef(val AvaVal) AvaRef {
+	if val.Type != Ref {
+		runtimePanic("Cannot dereference value of type %s", val.Type)
+	}
+
+	return val.Value.(AvaRef)
+}
+
+// deref reads the value behind a reference, other values are returned as they are.
+func deref(val AvaVal) AvaVal {
+	if val.Type == Ref {
+		return val.Value.(AvaRef).Get()
+	}
+
+	return val
+}
+
+func (i *Interp) VisitDerefExpr(expr DerefExpr) AvaVal {
+	val := i.Visit(expr.Expr)
+	i.at(expr.Pos)
+
+	return i.derefRef(val).Get()
+}
+
+func (i *Interp) VisitEnumDecl(decl EnumDecl) AvaVal {
+	if _, ok := i.enums[decl.Name]; ok {
+		runtimePanic("Redefining enum %s is not allowed.", decl.Name)
+	}
+
+	i.enums[decl.Name] = EnumDefinition{
+		Name:     decl.Name,
+		Variants: decl.Variants,
+	}
+
+	return AvaVal{
+		Type: Void,
+	}
+}
+
+func (i *Interp) VisitTraitDecl(_ TraitDecl) AvaVal {
+	return AvaVal{
+		Type: Void,
+	}
+}
+
+func (i *Interp) VisitImplDecl(decl ImplDecl) AvaVal {
+	methods, ok := i.methods[decl.Target.Name]
+	if !ok {
+		methods = make(map[string]FunctionDefinition)
+		i.methods[decl.Target.Name] = methods
+	}
+
+	for _, method := range decl.Methods {
+		methods[method.Name] = FunctionDefinition{
+			Name:   decl.Target.Name + "::" + method.Name,
+			Params: method.Params,
+			Body:   method.Body,
+			Env:    i.environment.Capture(),
+		}
+	}
+
+	return AvaVal{
+		Type: Void,
+	}
+}
+
+func (i *Interp) VisitTypeDecl(decl TypeDecl) AvaVal {
+	i.types[decl.Name] = decl.Type
 
 	return AvaVal{
 		Type: Void,
@@ -460,12 +1916,12 @@ func (i *Interp) VisitAssignStmt(stmt AssignStmt) AvaVal {
 
 func (i *Interp) VisitStructDecl(decl StructDecl) AvaVal {
 	if _, ok := i.structs[decl.Name]; ok {
-		fmt.Printf("Redefining struct %s is not allowed.\n", decl.Name)
-		os.Exit(1)
+		runtimePanic("Redefining struct %s is not allowed.", decl.Name)
 	}
 
 	v := StructDefinition{
-		Name: decl.Name,
+		Name:   decl.Name,
+		Fields: decl.Fields,
 	}
 	i.structs[decl.Name] = v
 
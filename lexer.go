@@ -4,28 +4,119 @@ import (
 	"bufio"
 	"errors"
 	"io"
-	"log"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
-	reader *bufio.Reader
+	reader *sourceReader
 }
 
 func NewLexer(reader io.Reader) *Lexer {
 	return &Lexer{
-		reader: bufio.NewReader(reader),
+		reader: &sourceReader{
+			Reader: bufio.NewReader(reader),
+			pos: Pos{
+				Line: 1,
+				Col:  1,
+			},
+		},
 	}
 }
 
+// sourceReader keeps track of the position in the source while it is read.
+type sourceReader struct {
+	*bufio.Reader
+
+	pos Pos
+	// prevPos is the position before the last rune, for UnreadRune.
+	prevPos Pos
+	// text holds the source read since the start of the current token.
+	text []byte
+	// prevText is the length of text before the last rune, for UnreadRune.
+	prevText int
+}
+
+func (r *sourceReader) advance(ch rune) {
+	r.prevPos = r.pos
+	r.prevText = len(r.text)
+	r.text = utf8.AppendRune(r.text, ch)
+	if ch == '\n' {
+		r.pos.Line++
+		r.pos.Col = 1
+	} else {
+		r.pos.Col++
+	}
+}
+
+func (r *sourceReader) ReadRune() (rune, int, error) {
+	ch, size, err := r.Reader.ReadRune()
+	if err == nil {
+		r.advance(ch)
+	}
+	return ch, size, err
+}
+
+func (r *sourceReader) UnreadRune() error {
+	err := r.Reader.UnreadRune()
+	if err == nil {
+		r.pos = r.prevPos
+		r.text = r.text[:r.prevText]
+	}
+	return err
+}
+
+func (r *sourceReader) ReadByte() (byte, error) {
+	b, err := r.Reader.ReadByte()
+	if err == nil {
+		r.advance(rune(b))
+	}
+	return b, err
+}
+
+func (r *sourceReader) Discard(n int) (int, error) {
+	bs, _ := r.Peek(n)
+	for _, b := range bs {
+		r.advance(rune(b))
+	}
+	return r.Reader.Discard(n)
+}
+
+// ReadAllTokens reads the tokens of the source up to EOF. Comments are not
+// tokens of their own, they are kept as trivia of the tokens next to them.
 func (l *Lexer) ReadAllTokens() []Token {
 	tokens := make([]Token, 0)
+	leading := make([]Token, 0)
 
 	for {
 		token := l.readNextToken()
+
+		if token.Type == LCOMMENT || token.Type == BCOMMENT {
+			// A comment on the line a token ends on trails it, others lead the
+			// next token
+			if n := len(tokens); n > 0 && len(leading) == 0 && token.Pos.Line == tokens[n-1].EndLine() {
+				tokens[n-1].Trailing = append(tokens[n-1].Trailing, token)
+			} else {
+				leading = append(leading, token)
+			}
+			continue
+		}
+
+		if len(leading) > 0 {
+			token.Leading = leading
+			leading = make([]Token, 0)
+		}
 		tokens = append(tokens, token)
 
+		// Positions after `loc a::b;` name the program, like the parser does
+		if token.Type == SEMI && l.reader.pos.File == "" && tokens[0].Type == KEYWORD && tokens[0].Data == "loc" {
+			for _, t := range tokens[1 : len(tokens)-1] {
+				l.reader.pos.File += t.Data
+			}
+		}
+
 		if token.Type == EOF {
 			break
 		}
@@ -35,6 +126,24 @@ func (l *Lexer) ReadAllTokens() []Token {
 }
 
 func (l *Lexer) readNextToken() Token {
+	for {
+		rs, err := l.reader.Peek(1)
+		if err != nil || !unicode.IsSpace(rune(rs[0])) {
+			break
+		}
+		l.reader.ReadRune()
+	}
+
+	pos := l.reader.pos
+	l.reader.text = l.reader.text[:0]
+	token := l.readToken()
+	token.Pos = pos
+	token.Raw = string(l.reader.text)
+
+	return token
+}
+
+func (l *Lexer) readToken() Token {
 	for {
 		rs, err := l.reader.Peek(1)
 
@@ -46,7 +155,7 @@ func (l *Lexer) readNextToken() Token {
 				}
 			}
 
-			log.Fatalf("Error reading input: %v\n", err)
+			syntaxError("Error reading input: %v\n", err)
 		}
 
 		r := rune(rs[0])
@@ -60,7 +169,9 @@ func (l *Lexer) readNextToken() Token {
 			return l.readOperator()
 		} else if unicode.IsDigit(r) {
 			return l.readNumericLiteral()
-		} else if unicode.IsLetter(r) {
+		} else if rs, _ := l.reader.Peek(2); string(rs) == `r"` {
+			return l.readRawStrLiteral()
+		} else if unicode.IsLetter(r) || r == '_' {
 			return l.readIdentOrKeyword()
 		} else if r == '(' {
 			return l.readSingleChar(LPAREN)
@@ -70,38 +181,54 @@ func (l *Lexer) readNextToken() Token {
 			return l.readSingleChar(LCURLY)
 		} else if r == '}' {
 			return l.readSingleChar(RCURLY)
+		} else if r == '[' {
+			return l.readSingleChar(LBRACKET)
+		} else if r == ']' {
+			return l.readSingleChar(RBRACKET)
 		} else if r == ';' {
 			return l.readSingleChar(SEMI)
 		} else if r == ',' {
 			return l.readSingleChar(COMMA)
 		} else if r == '"' {
 			return l.readStrLiteral()
+		} else if r == '\'' {
+			return l.readCharLiteral()
 		}
 
-		log.Fatalf("Invalid rune: %s\n", string(r))
+		syntaxError("Invalid rune: %s\n", string(r))
 	}
 }
 
 func (l *Lexer) readDivisionOrComment() Token {
+	start := l.reader.pos
 	_, _, _ = l.reader.ReadRune()
 
-	r, _, err := l.reader.ReadRune()
-	if err != nil {
-		panic(err)
+	rs, _ := l.reader.Peek(1)
+	if len(rs) == 0 || (rs[0] != '/' && rs[0] != '*') {
+		data := "/"
+		if string(rs) == "=" {
+			_, _, _ = l.reader.ReadRune()
+			data = "/="
+		}
+
+		return Token{
+			Type: OPERATOR,
+			Data: data,
+		}
 	}
 
+	r, _, _ := l.reader.ReadRune()
 	sb := strings.Builder{}
 	if r == '/' {
+		// The line break is not part of the comment, which may also end the
+		// source
 		for {
-			r, _, err = l.reader.ReadRune()
-			if err != nil {
-				panic(err)
-			}
-
-			if r == '\n' {
+			rs, err := l.reader.Peek(1)
+			if err != nil || rs[0] == '\n' {
 				break
 			}
 
+			r, _, _ := l.reader.ReadRune()
 			sb.WriteRune(r)
 		}
 
@@ -109,67 +236,249 @@ func (l *Lexer) readDivisionOrComment() Token {
 			Type: LCOMMENT,
 			Data: sb.String(),
 		}
-	} else if r == '*' {
-		for {
-			rs, err := l.reader.Peek(2)
-			if err != nil {
-				panic(err)
-			}
+	}
+
+	// Block comments nest, so a block of code with comments in it can be
+	// commented out
+	depth := 1
+	for {
+		rs, err := l.reader.Peek(2)
+		if err != nil {
+			syntaxError("Unterminated block comment starting at %s\n", start)
+		}
 
-			if rs[0] == '*' && rs[1] == '/' {
+		if string(rs) == "*/" {
+			_, _ = l.reader.Discard(2)
+			depth--
+			if depth == 0 {
 				break
 			}
+			sb.WriteString("*/")
+			continue
+		} else if string(rs) == "/*" {
+			_, _ = l.reader.Discard(2)
+			depth++
+			sb.WriteString("/*")
+			continue
+		}
 
-			r, _, err := l.reader.ReadRune()
-			if err != nil {
-				panic(err)
-			}
+		r, _, _ := l.reader.ReadRune()
+		sb.WriteRune(r)
+	}
 
-			sb.WriteRune(r)
+	return Token{
+		Type: BCOMMENT,
+		Data: sb.String(),
+	}
+}
+
+func (l *Lexer) readStrLiteral() Token {
+	start := l.reader.pos
+	_, _, _ = l.reader.ReadRune()
+
+	// Triple-quoted strings can span lines. A line break right after the
+	// opening quotes is not part of the string.
+	rs, _ := l.reader.Peek(2)
+	triple := string(rs) == `""`
+	if triple {
+		_, _ = l.reader.Discard(2)
+		if rs, _ := l.reader.Peek(1); string(rs) == "\n" {
+			_, _, _ = l.reader.ReadRune()
+		}
+	}
+
+	sb := strings.Builder{}
+	parts := make([]StrPart, 0)
+
+	for {
+		pos := l.reader.pos
+		r, _, err := l.reader.ReadRune()
+		if err != nil || (r == '\n' && !triple) {
+			syntaxError("Unterminated string starting at %s\n", start)
+		}
+
+		if r == '"' && !triple {
+			break
+		} else if r == '"' {
+			if rs, _ := l.reader.Peek(2); string(rs) == `""` {
+				_, _ = l.reader.Discard(2)
+				break
+			}
+		} else if r == '\\' {
+			r = l.readEscape(pos)
+		} else if r == '{' {
+			parts = append(parts, StrPart{Text: sb.String()})
+			sb.Reset()
+			parts = append(parts, StrPart{Tokens: l.readInterpolation(pos)})
+			continue
+		} else if r == '}' {
+			syntaxError("Unmatched } in string at %s, use \\} for a literal brace\n", pos)
 		}
 
+		sb.WriteRune(r)
+	}
+
+	data := sb.String()
+	if len(parts) == 0 {
 		return Token{
-			Type: BCOMMENT,
-			Data: sb.String(),
+			Type: STRING,
+			Data: data,
 		}
 	}
 
+	parts = append(parts, StrPart{Text: data})
 	return Token{
-		Type: OPERATOR,
-		Data: "/",
+		Type:  ISTRING,
+		Parts: parts,
 	}
 }
 
-func (l *Lexer) readStrLiteral() Token {
-	_, _, err := l.reader.ReadRune()
+// readInterpolation reads the expression after the `{` at pos in a string up
+// to the matching `}` and returns its tokens.
+func (l *Lexer) readInterpolation(pos Pos) []Token {
+	exprPos := l.reader.pos
+	sb := strings.Builder{}
+	depth := 0
+	// quote is the quote of the string or character literal being read, a
+	// brace in it does not end the expression
+	quote := rune(0)
+
+	for {
+		r, _, err := l.reader.ReadRune()
+		if err != nil || r == '\n' {
+			syntaxError("Unterminated interpolation starting at %s\n", pos)
+		}
+
+		if quote != 0 {
+			if r == '\\' {
+				sb.WriteRune(r)
+				r, _, _ = l.reader.ReadRune()
+			} else if r == quote {
+				quote = 0
+			}
+		} else if r == '"' || r == '\'' {
+			quote = r
+		} else if r == '{' {
+			depth++
+		} else if r == '}' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+
+		sb.WriteRune(r)
+	}
+
+	if strings.TrimSpace(sb.String()) == "" {
+		syntaxError("Empty interpolation at %s\n", pos)
+	}
+
+	lexer := NewLexer(strings.NewReader(sb.String()))
+	lexer.reader.pos = exprPos
+	return lexer.ReadAllTokens()
+}
+
+// readEscape reads the escape sequence after a backslash at pos.
+func (l *Lexer) readEscape(pos Pos) rune {
+	r, _, err := l.reader.ReadRune()
 	if err != nil {
-		panic(err)
+		syntaxError("Unterminated escape sequence at %s\n", pos)
+	}
+
+	switch r {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	case '0':
+		return 0
+	case '\\', '"', '\'', '{', '}':
+		return r
+	case 'u':
+		return l.readUnicodeEscape(pos)
+	}
+
+	syntaxError("Unknown escape sequence \\%c at %s\n", r, pos)
+	return 0
+}
+
+// readUnicodeEscape reads the code point of `\u{1F600}`.
+func (l *Lexer) readUnicodeEscape(pos Pos) rune {
+	if r, _, _ := l.reader.ReadRune(); r != '{' {
+		syntaxError("Expected { after \\u at %s\n", pos)
 	}
 
 	sb := strings.Builder{}
+	for {
+		r, _, err := l.reader.ReadRune()
+		if err != nil || r == '"' {
+			syntaxError("Unterminated unicode escape at %s\n", pos)
+		}
+
+		if r == '}' {
+			break
+		}
+
+		sb.WriteRune(r)
+	}
+
+	code, err := strconv.ParseUint(sb.String(), 16, 32)
+	if err != nil || sb.Len() > 6 || !utf8.ValidRune(rune(code)) {
+		syntaxError("Invalid unicode escape \\u{%s} at %s\n", sb.String(), pos)
+	}
 
-	escaping := false
+	return rune(code)
+}
 
+func (l *Lexer) readCharLiteral() Token {
+	start := l.reader.pos
+	_, _, _ = l.reader.ReadRune()
+
+	pos := l.reader.pos
+	r, _, err := l.reader.ReadRune()
+	if err != nil || r == '\n' {
+		syntaxError("Unterminated character literal at %s\n", start)
+	} else if r == '\'' {
+		syntaxError("Empty character literal at %s\n", start)
+	} else if r == '\\' {
+		r = l.readEscape(pos)
+	}
+
+	if end, _, err := l.reader.ReadRune(); err != nil || end != '\'' {
+		syntaxError("Character literal at %s must hold exactly one character\n", start)
+	}
+
+	return Token{
+		Type: CHAR,
+		Data: string(r),
+	}
+}
+
+// readRawStrLiteral reads `r"..."`, where backslashes are kept as they are.
+func (l *Lexer) readRawStrLiteral() Token {
+	start := l.reader.pos
+	_, _ = l.reader.Discard(2)
+
+	sb := strings.Builder{}
 	for {
 		r, _, err := l.reader.ReadRune()
 		if err != nil {
-			panic(err)
+			syntaxError("Unterminated string starting at %s\n", start)
 		}
 
-		if r == '\\' {
-			escaping = true
-			continue
-		} else if r == '"' && !escaping {
+		if r == '"' {
 			break
 		}
 
 		sb.WriteRune(r)
 	}
 
-	data := sb.String()
 	return Token{
 		Type: STRING,
-		Data: data,
+		Data: sb.String(),
 	}
 }
 
@@ -190,11 +499,13 @@ func (l *Lexer) readIdentOrKeyword() Token {
 
 	for {
 		r, _, err := l.reader.ReadRune()
-		if err != nil {
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
 			panic(err)
 		}
 
-		if !(unicode.IsDigit(r) || unicode.IsLetter(r)) {
+		if !(unicode.IsDigit(r) || unicode.IsLetter(r) || r == '_') {
 			l.reader.UnreadRune()
 			break
 		}
@@ -211,6 +522,8 @@ func (l *Lexer) readIdentOrKeyword() Token {
 		typ = ITYPE
 	} else if data == "true" || data == "false" {
 		typ = BOOL
+	} else if data == "nil" {
+		typ = NIL
 	}
 
 	return Token{
@@ -220,10 +533,13 @@ func (l *Lexer) readIdentOrKeyword() Token {
 }
 
 var keywords = []string{
-	"if", "while",
-	"var", "fun", "const",
+	"if", "while", "for", "in",
+	"var", "fun", "const", "return", "defer",
 	"loc", "use",
-	"struct", "impl",
+	"struct", "impl", "enum", "type",
+	"trait", "dyn",
+	"map", "match",
+	"try",
 }
 
 var intrinsicTypes = []string{
@@ -233,27 +549,29 @@ var intrinsicTypes = []string{
 	"u64", "i64",
 	"void",
 	"f32", "f64",
-	"str",
+	"str", "char",
 }
 
 func (l *Lexer) readOperator() Token {
-	builder := strings.Builder{}
-
-	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			panic(err)
-		}
-
-		if !contains(opRunes, r) {
-			l.reader.UnreadRune()
+	// Longest match wins, so `..=` is not read as `..` followed by `=`.
+	data := ""
+	for n := maxOperatorLen; n > 0; n-- {
+		rs, _ := l.reader.Peek(n)
+		if len(rs) == n && contains(operators, string(rs)) {
+			data = string(rs)
 			break
 		}
+	}
 
-		builder.WriteRune(r)
+	if data == "" {
+		rs, _ := l.reader.Peek(1)
+		syntaxError("Invalid operator: %s\n", string(rs))
 	}
 
-	data := builder.String()
+	_, err := l.reader.Discard(len(data))
+	if err != nil {
+		panic(err)
+	}
 
 	return Token{
 		Type: OPERATOR,
@@ -261,58 +579,44 @@ func (l *Lexer) readOperator() Token {
 	}
 }
 
+// readNumericLiteral reads a number with its prefix, digit separators and
+// type suffix, e.g. `0xff`, `1_000` or `3.0f32`. The parser checks the digits.
 func (l *Lexer) readNumericLiteral() Token {
 	var typ TokenType = INT
 	sb := strings.Builder{}
 
+	if rs, _ := l.reader.Peek(2); string(rs) == "0x" {
+		typ = HEX
+	}
+
 	for {
-		r, _, err := l.reader.ReadRune()
+		rs, err := l.reader.Peek(1)
 		if err != nil {
 			if !errors.Is(err, io.EOF) {
-				log.Fatalf("Error reading input: %v\n", err)
+				syntaxError("Error reading input: %v\n", err)
 			}
 			break
 		}
 
+		r := rune(rs[0])
 		if r == '.' {
-			typ = FLOAT
-		} else if r == 'x' {
-			typ = HEX
-		} else if !unicode.IsDigit(r) {
-			err = l.reader.UnreadRune()
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				log.Fatalf("Error unreading from input: %v\n", err)
+			// A dot that is not followed by a digit starts a range operator, e.g. `0..n`.
+			rs, _ = l.reader.Peek(2)
+			if typ != INT || len(rs) < 2 || !unicode.IsDigit(rune(rs[1])) {
+				break
 			}
+			typ = FLOAT
+		} else if !(unicode.IsDigit(r) || unicode.IsLetter(r) || r == '_') {
 			break
 		}
 
+		_, _ = l.reader.ReadByte()
 		sb.WriteRune(r)
 	}
 
-	data := sb.String()
-
-	// TODO: Add other types
-	switch typ {
-	case INT:
-		if len(data) >= 2 && data[0] == '0' && data[1] == '0' {
-			log.Fatalf("Illegal integer: %s\n", data)
-		}
-	case HEX:
-		if len(data) >= 2 && data[0] == '0' && data[1] == '0' {
-			log.Fatalf("Illegal integer: %s\n", data)
-		}
-	case FLOAT:
-		if len(data) >= 2 && data[0] == '0' && data[1] == '0' {
-			log.Fatalf("Illegal integer: %s\n", data)
-		}
-	}
-
 	return Token{
 		Type: typ,
-		Data: data,
+		Data: sb.String(),
 	}
 }
 
@@ -327,14 +631,17 @@ func contains[T comparable](arr []T, val T) bool {
 }
 
 var operators = []string{
-	".", "::", "->", ":",
+	".", "::", "->", "=>", ":", "=",
+	"..", "..=", "...",
 	"+", "-", "*", "/",
 	"%", "<", ">", "<=", ">=", "==", "!=",
 	"&", "&&", "|", "||",
+	"?", "??", "?.", "!",
+	"+=", "-=", "*=", "%=", "++", "--",
+	"&=", "|=", "^=", "<<=", ">>=",
 }
-var opRunes = Map(operators, func(op string) rune {
-	return rune(op[0])
-})
+
+var maxOperatorLen = 3
 
 func couldBeOperator(r rune) bool {
 	for _, op := range operators {
@@ -16,7 +16,11 @@ func printHelp() {
 	- com <file> - compiles given file
 	  -out - specify output path (default "a.out")
 	  -verbose - print out debug information of compilation (default false)
-	- run <file> - interprets given file
+	- run <file> [-- args] - interprets given file, passing args to main
+	- repl - starts an interactive session
+	- fmt [-w] [-check] <file> - prints given file formatted
+	  -w - rewrite the file instead of printing it
+	  -check - exit with 1 when the file is not formatted
 	- version - prints version`)
 	os.Exit(0)
 }
@@ -45,7 +49,7 @@ func runInterpreter(fileName string) {
 	}
 
 	interp := NewInterpretator(file)
-	interp.Run()
+	os.Exit(interp.Run())
 }
 
 func runCompilation(fileName string, outPath *string) {
@@ -61,7 +65,12 @@ func main() {
 	IsVerbose = *verbose
 	IsDebug = *debug
 
-	args := flag.Args()
+	args, progArgs := splitProgramArgs(flag.Args())
+	programArgs = progArgs
+
+	if len(args) > 0 && args[0] == "fmt" {
+		runFormatter(args[1:])
+	}
 
 	if len(args) < 1 {
 		printHelp()
@@ -72,6 +81,9 @@ func main() {
 			printHelp()
 		case "version":
 			printVersion()
+		case "repl":
+			runRepl()
+			os.Exit(0)
 		}
 	} else if len(args) == 2 {
 		fileName := args[1]
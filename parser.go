@@ -1,17 +1,30 @@
 package main
 
 import (
-	"log"
-	"math/big"
+	"errors"
+	"math"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Parser struct {
 	tokens []Token
 	i      int
 
+	// globalSpace is set outside of blocks, where declarations are global.
 	globalSpace bool
+	// noStructLit is set while parsing an expression in front of a block, like
+	// the condition of an if, where `x {` starts the block and not a struct literal.
+	noStructLit bool
+	// selfType is the type `Self` stands for inside an impl block.
+	selfType TypeSpec
+	// aliases maps the name of a type alias to the index of its type in
+	// tokens, until the type is parsed. Then it maps to the type.
+	aliases map[string]any
+	// loc names the program in source positions.
+	loc string
 }
 
 func NewParser(tokens []Token) *Parser {
@@ -23,6 +36,7 @@ func NewParser(tokens []Token) *Parser {
 }
 
 func (p *Parser) Parse() ProgStmt {
+	p.scanAliases()
 	loc := p.locStmt()
 
 	glblStmts := make([]GlblStmt, 0)
@@ -46,17 +60,31 @@ func (p *Parser) Parse() ProgStmt {
 }
 
 func (p *Parser) glblStmt() GlblStmt {
-	p.expectAny([]string{"fun", "const", "var", "struct"})
+	p.expectAny([]string{"fun", "const", "var", "struct", "enum", "trait", "impl", "type"})
 	t := p.consume()
 
 	if t.Data == "fun" {
-		return p.funcDecl()
+		return p.funcDecl(t)
 	} else if t.Data == "const" {
-		return p.constDecl()
+		if n := p.cur(); n.Type == KEYWORD && n.Data == "fun" {
+			p.consume()
+			decl := p.funcDecl(t)
+			decl.IsConst = true
+			return decl
+		}
+		return p.constDecl(t)
 	} else if t.Data == "var" {
-		return p.varDecl()
+		return p.varDecl(t)
 	} else if t.Data == "struct" {
-		return p.structDecl()
+		return p.structDecl(t)
+	} else if t.Data == "enum" {
+		return p.enumDecl(t)
+	} else if t.Data == "trait" {
+		return p.traitDecl(t)
+	} else if t.Data == "impl" {
+		return p.implDecl(t)
+	} else if t.Data == "type" {
+		return p.typeDecl(t)
 	}
 
 	return FuncDecl{}
@@ -67,59 +95,155 @@ func (p *Parser) stmt() Stmt {
 
 	if t.Data == "var" {
 		p.consume()
-		return p.varDecl()
+		if p.cur().Type == LPAREN {
+			return p.tupleVarDecl(t)
+		}
+		return p.varDecl(t)
+	} else if t.Data == "const" {
+		p.consume()
+		return p.constDecl(t)
+	} else if t.Data == "return" {
+		p.consume()
+		return p.returnStmt(t)
+	} else if t.Data == "defer" {
+		p.consume()
+		stmt := DeferStmt{
+			Expr: p.expr(),
+			Pos:  p.pos(t),
+		}
+		p.expectAndConsume(SEMI, "")
+		return stmt
 	} else if t.Data == "if" {
 		p.consume()
-		return p.ifStmt()
+		return p.ifStmt(t)
 	} else if t.Data == "while" {
 		p.consume()
-		return p.whileStmt()
-	} else if t.Type == IDENT {
-		return p.assignmentOrExpr()
+		return p.whileStmt(t)
+	} else if t.Data == "for" {
+		p.consume()
+		return p.forStmt(t)
 	}
 
-	expr := p.expr()
-	p.expectAndConsume(SEMI, "")
-	return ExprStmt{
-		Expr: expr,
-	}
+	return p.assignmentOrExpr()
 }
 
 func (p *Parser) assignmentOrExpr() Stmt {
-	next := p.next()
+	t := p.cur()
+	expr := p.expr()
 
-	if !(next.Type == OPERATOR && next.Data == "=") {
-		expr := p.expr()
+	// `n++` adds 1 to n, `n--` subtracts 1
+	if n := p.cur(); n.Type == OPERATOR && (n.Data == "++" || n.Data == "--") {
+		op := p.consume()
 		p.expectAndConsume(SEMI, "")
+
+		return AssignStmt{
+			Target: expr,
+			Value: IntLit{
+				Value: 1,
+				Pos:   p.pos(op),
+			},
+			Op:        op.Data[:1],
+			IsPostfix: true,
+			Pos:       p.pos(op),
+		}
+	}
+
+	if n := p.cur(); !(n.Type == OPERATOR && (n.Data == "=" || contains(compoundOps, n.Data))) {
+		// A match used as a statement does not need a semicolon
+		if _, ok := expr.(MatchExpr); !ok || p.cur().Type == SEMI {
+			p.expectAndConsume(SEMI, "")
+		}
 		return ExprStmt{
 			Expr: expr,
+			Pos:  p.pos(t),
 		}
 	}
 
-	// Assignment
-	variable := p.consume()
-	p.consume() // =
-	expr := p.expr()
+	// Assignment, `a += b` keeps the operator
+	op := p.consume()
+	value := p.expr()
 	p.expectAndConsume(SEMI, "")
 
 	return AssignStmt{
-		Variable: variable.Data,
-		Value:    expr,
+		Target: expr,
+		Value:  value,
+		Op:     strings.TrimSuffix(op.Data, "="),
+		Pos:    p.pos(op),
+	}
+}
+
+var compoundOps = []string{
+	"+=", "-=", "*=", "/=", "%=",
+	"&=", "|=", "^=", "<<=", ">>=",
+}
+
+func (p *Parser) returnStmt(t Token) ReturnStmt {
+	if p.cur().Type == SEMI {
+		p.consume()
+		return ReturnStmt{
+			Pos: p.pos(t),
+		}
+	}
+
+	// `return a, b;` returns a tuple
+	values := []Expr{p.expr()}
+	for p.cur().Type == COMMA {
+		p.consume()
+		values = append(values, p.expr())
+	}
+	p.expectAndConsume(SEMI, "")
+
+	if len(values) == 1 {
+		return ReturnStmt{
+			Value: values[0],
+			Pos:   p.pos(t),
+		}
+	}
+
+	return ReturnStmt{
+		Value: TupleLit{
+			Elems: values,
+		},
+		Pos: p.pos(t),
 	}
 }
 
-func (p *Parser) whileStmt() WhileStmt {
-	cond := p.compExpr()
+func (p *Parser) whileStmt(t Token) WhileStmt {
+	cond := p.condExpr()
 	body := p.block()
 
 	return WhileStmt{
 		Condition: cond,
 		Body:      body,
+		Pos:       p.pos(t),
+	}
+}
+
+func (p *Parser) forStmt(t Token) ForStmt {
+	key := ""
+	value := p.expectAndConsume(IDENT, "").Data
+
+	if p.cur().Type == COMMA {
+		p.consume()
+		key = value
+		value = p.expectAndConsume(IDENT, "").Data
+	}
+
+	p.expectAndConsume(KEYWORD, "in")
+	iterable := p.condExpr()
+	body := p.block()
+
+	return ForStmt{
+		Key:      key,
+		Value:    value,
+		Iterable: iterable,
+		Body:     body,
+		Pos:      p.pos(t),
 	}
 }
 
-func (p *Parser) ifStmt() IfStmt {
-	cond := p.compExpr()
+func (p *Parser) ifStmt(t Token) IfStmt {
+	cond := p.condExpr()
 	thenBlock := p.block()
 	elseBlock := Block{}
 	hasElse := false
@@ -135,10 +259,11 @@ func (p *Parser) ifStmt() IfStmt {
 		ThenBody:  thenBlock,
 		HasElse:   hasElse,
 		ElseBody:  elseBlock,
+		Pos:       p.pos(t),
 	}
 }
 
-func (p *Parser) structBodyDecl() []StructField {
+func (p *Parser) structBodyDecl() ([]StructField, Token) {
 	p.expectAndConsume(LCURLY, "")
 
 	fields := make([]StructField, 0)
@@ -152,32 +277,269 @@ func (p *Parser) structBodyDecl() []StructField {
 		variable := p.expectAndConsume(IDENT, "")
 		p.expectAndConsume(OPERATOR, ":")
 
-		p.expectAnyType([]TokenType{ITYPE, IDENT})
-		typ := p.consume()
+		typ := p.typeSpec()
 		p.expectAndConsume(COMMA, ",")
 
 		field := StructField{
 			Name: variable.Data,
-			Type: typ.Data,
+			Type: typ,
+			Pos:  p.pos(variable),
 		}
 		fields = append(fields, field)
 	}
 
-	p.expectAndConsume(RCURLY, "")
-	return fields
+	end := p.expectAndConsume(RCURLY, "")
+	return fields, end
+}
+
+func (p *Parser) typeDecl(t Token) TypeDecl {
+	name := p.expectAndConsume(IDENT, "")
+	p.expectAndConsume(OPERATOR, "=")
+
+	distinct := false
+	if n := p.cur(); n.Type == IDENT && n.Data == "distinct" {
+		p.consume()
+		distinct = true
+	}
+
+	typ := p.typeSpec()
+	p.expectAndConsume(SEMI, "")
+
+	return TypeDecl{
+		Name:       name.Data,
+		Type:       typ,
+		IsDistinct: distinct,
+		Pos:        p.pos(t),
+	}
+}
+
+// scanAliases finds the type aliases of the program before it is parsed, so
+// a type can use an alias that is declared after it.
+func (p *Parser) scanAliases() {
+	p.aliases = make(map[string]any)
+
+	for k := 0; k+3 < len(p.tokens); k++ {
+		t := p.tokens[k]
+		if !(t.Type == KEYWORD && t.Data == "type") || p.tokens[k+1].Type != IDENT || p.tokens[k+2].Data != "=" {
+			continue
+		}
+
+		if n := p.tokens[k+3]; n.Type == IDENT && n.Data == "distinct" {
+			continue
+		}
+		p.aliases[p.tokens[k+1].Data] = k + 3
+	}
+}
+
+// alias returns the type an alias stands for. The type is parsed the first
+// time the alias is used.
+func (p *Parser) alias(t Token) (TypeSpec, bool) {
+	switch alias := p.aliases[t.Data].(type) {
+	case TypeSpec:
+		return alias, true
+	case int:
+		// A nil entry marks an alias whose type is being parsed
+		p.aliases[t.Data] = nil
+
+		i := p.i
+		p.i = alias
+		typ := p.typeSpec()
+		p.i = i
+
+		p.aliases[t.Data] = typ
+		return typ, true
+	}
+
+	if _, ok := p.aliases[t.Data]; ok {
+		syntaxError("Type alias %s refers to itself at %s\n", t.Data, p.pos(t))
+	}
+
+	return TypeSpec{}, false
 }
 
-func (p *Parser) structDecl() StructDecl {
+func (p *Parser) structDecl(t Token) StructDecl {
 	name := p.expectAndConsume(IDENT, "")
-	fields := p.structBodyDecl()
+	typeParams := p.typeParams()
+	fields, end := p.structBodyDecl()
 	p.expectAndConsume(SEMI, "")
 	return StructDecl{
-		Name:   name.Data,
-		Fields: fields,
+		Name:       name.Data,
+		TypeParams: typeParams,
+		Fields:     fields,
+		Pos:        p.pos(t),
+		End:        p.pos(end),
+	}
+}
+
+func (p *Parser) typeParams() []TypeParam {
+	params := make([]TypeParam, 0)
+
+	if n := p.cur(); !(n.Type == OPERATOR && n.Data == "<") {
+		return params
+	}
+	p.consume()
+
+	for {
+		name := p.expectAndConsume(IDENT, "")
+		param := TypeParam{
+			Name: name.Data,
+		}
+
+		// Bounds, e.g. `T: Printable + Named`
+		if n := p.cur(); n.Type == OPERATOR && n.Data == ":" {
+			p.consume()
+			for {
+				param.Bounds = append(param.Bounds, p.expectAndConsume(IDENT, "").Data)
+
+				if n := p.cur(); !(n.Type == OPERATOR && n.Data == "+") {
+					break
+				}
+				p.consume()
+			}
+		}
+		params = append(params, param)
+
+		if n := p.cur(); n.Type == OPERATOR && n.Data == ">" {
+			break
+		}
+
+		p.expectAndConsume(COMMA, "")
+	}
+	p.expectAndConsume(OPERATOR, ">")
+
+	return params
+}
+
+func (p *Parser) traitDecl(t Token) TraitDecl {
+	name := p.expectAndConsume(IDENT, "")
+	p.expectAndConsume(LCURLY, "")
+
+	methods := make([]FuncDecl, 0)
+	for {
+		if p.cur().Type == RCURLY {
+			break
+		}
+
+		fun := p.expectAndConsume(KEYWORD, "fun")
+		method := p.funcSignature()
+		method.Pos = p.pos(fun)
+		methods = append(methods, method)
+		p.expectAndConsume(SEMI, "")
+	}
+	end := p.expectAndConsume(RCURLY, "")
+
+	if p.cur().Type == SEMI {
+		p.consume()
+	}
+
+	return TraitDecl{
+		Name:    name.Data,
+		Methods: methods,
+		Pos:     p.pos(t),
+		End:     p.pos(end),
+	}
+}
+
+func (p *Parser) implDecl(t Token) ImplDecl {
+	typeParams := p.typeParams()
+	target := p.typeSpec()
+
+	// `impl Printable for Vec2`
+	trait := ""
+	if n := p.cur(); n.Type == KEYWORD && n.Data == "for" {
+		p.consume()
+		trait = target.Name
+		target = p.typeSpec()
+	}
+
+	p.selfType = target
+	p.expectAndConsume(LCURLY, "")
+
+	methods := make([]FuncDecl, 0)
+	for {
+		if p.cur().Type == RCURLY {
+			break
+		}
+
+		fun := p.expectAndConsume(KEYWORD, "fun")
+		methods = append(methods, p.funcDecl(fun))
+	}
+	end := p.expectAndConsume(RCURLY, "")
+	p.selfType = TypeSpec{}
+
+	if p.cur().Type == SEMI {
+		p.consume()
+	}
+
+	return ImplDecl{
+		TypeParams: typeParams,
+		Trait:      trait,
+		Target:     target,
+		Methods:    methods,
+		Pos:        p.pos(t),
+		End:        p.pos(end),
+	}
+}
+
+func (p *Parser) enumDecl(t Token) EnumDecl {
+	name := p.expectAndConsume(IDENT, "")
+	typeParams := p.typeParams()
+	p.expectAndConsume(LCURLY, "")
+
+	variants := make([]EnumVariant, 0)
+	for {
+		if p.cur().Type == RCURLY {
+			break
+		}
+
+		name := p.expectAndConsume(IDENT, "")
+		variant := EnumVariant{
+			Name:   name.Data,
+			Fields: make([]TypeSpec, 0),
+			Pos:    p.pos(name),
+		}
+
+		if p.cur().Type == LPAREN {
+			p.consume()
+			for {
+				if p.cur().Type == RPAREN {
+					break
+				}
+
+				variant.Fields = append(variant.Fields, p.typeSpec())
+
+				if p.cur().Type == RPAREN {
+					break
+				}
+
+				p.expectAndConsume(COMMA, "")
+			}
+			p.expectAndConsume(RPAREN, "")
+		}
+		variants = append(variants, variant)
+
+		if p.cur().Type == RCURLY {
+			break
+		}
+
+		p.expectAndConsume(COMMA, "")
+	}
+	end := p.expectAndConsume(RCURLY, "")
+
+	if p.cur().Type == SEMI {
+		p.consume()
+	}
+
+	return EnumDecl{
+		Name:       name.Data,
+		TypeParams: typeParams,
+		Variants:   variants,
+		Pos:        p.pos(t),
+		End:        p.pos(end),
 	}
 }
 
-func (p *Parser) varDecl() VarDecl {
+func (p *Parser) varDecl(v Token) VarDecl {
 	t := p.expectAndConsume(IDENT, "")
 	name := t.Data
 
@@ -196,10 +558,41 @@ func (p *Parser) varDecl() VarDecl {
 		Name: name,
 		Type: typ,
 		Init: init,
+		Pos:  p.pos(v),
+	}
+}
+
+func (p *Parser) tupleVarDecl(t Token) TupleVarDecl {
+	p.expectAndConsume(LPAREN, "")
+
+	names := make([]string, 0)
+	for {
+		t := p.expectAndConsume(IDENT, "")
+		names = append(names, t.Data)
+
+		if p.cur().Type == RPAREN {
+			break
+		}
+
+		p.expectAndConsume(COMMA, "")
+	}
+	p.expectAndConsume(RPAREN, "")
+
+	typ := p.varType()
+
+	p.expectAndConsume(OPERATOR, "=")
+	init := p.expr()
+	p.expectAndConsume(SEMI, "")
+
+	return TupleVarDecl{
+		Names: names,
+		Type:  typ,
+		Init:  init,
+		Pos:   p.pos(t),
 	}
 }
 
-func (p *Parser) constDecl() ConstDecl {
+func (p *Parser) constDecl(c Token) ConstDecl {
 	t := p.expectAndConsume(IDENT, "")
 	name := t.Data
 
@@ -221,33 +614,131 @@ func (p *Parser) constDecl() ConstDecl {
 		Type:     typ,
 		Init:     init,
 		IsGlobal: p.globalSpace,
+		Value:    &AvaVal{},
+		Pos:      p.pos(c),
 	}
 }
 
 func (p *Parser) expr() Expr {
-	return p.addExpr()
+	return p.orExpr()
+}
+
+func (p *Parser) condExpr() Expr {
+	prev := p.noStructLit
+	p.noStructLit = true
+	e := p.expr()
+	p.noStructLit = prev
+
+	return e
+}
+
+// nestedExpr parses an expression enclosed in brackets, where struct literals
+// are allowed again.
+func (p *Parser) nestedExpr() Expr {
+	prev := p.noStructLit
+	p.noStructLit = false
+	e := p.expr()
+	p.noStructLit = prev
+
+	return e
+}
+
+/// Range
+///  : Additive
+///  | Additive (..|..=) Additive
+func (p *Parser) rangeExpr() Expr {
+	l := p.addExpr()
+
+	n := p.cur()
+	if !(n.Type == OPERATOR && (n.Data == ".." || n.Data == "..=")) {
+		return l
+	}
+
+	op := p.consume()
+	r := p.addExpr()
+
+	return RangeExpr{
+		Start:     l,
+		End:       r,
+		Inclusive: op.Data == "..=",
+	}
 }
 
 var compOps = []string{
 	"<", ">", "<=", ">=", "==", "!=",
-	"&", "&&", "|", "||",
+}
+
+/// Or
+///  : And
+///  | Or (|||) And
+func (p *Parser) orExpr() Expr {
+	return p.logicalExpr([]string{"||", "|"}, p.andExpr)
+}
+
+/// And
+///  : Comparison
+///  | And (&&|&) Comparison
+func (p *Parser) andExpr() Expr {
+	return p.logicalExpr([]string{"&&", "&"}, p.compExpr)
+}
+
+func (p *Parser) logicalExpr(ops []string, operand func() Expr) Expr {
+	l := operand()
+
+	for {
+		n := p.cur()
+		if !(n.Type == OPERATOR && contains(ops, n.Data)) {
+			break
+		}
+
+		op := p.consume()
+
+		r := operand()
+		l = FuncCall{
+			Name:         op.Data,
+			IsComparison: true,
+			Args:         []Expr{l, r},
+			Pos:          p.pos(op),
+		}
+	}
+
+	return l
 }
 
 func (p *Parser) compExpr() Expr {
-	l := p.addExpr()
+	l := p.coalesceExpr()
 
-	if p.cur().Type != OPERATOR {
+	n := p.cur()
+	if !(n.Type == OPERATOR && contains(compOps, n.Data)) {
 		return l
 	}
 
-	p.expectAny(compOps)
 	op := p.consume()
-	r := p.addExpr()
+	r := p.coalesceExpr()
 
 	return FuncCall{
 		Name:         op.Data,
 		IsComparison: true,
 		Args:         []Expr{l, r},
+		Pos:          p.pos(op),
+	}
+}
+
+/// Coalesce
+///  : Range
+///  | Range ?? Coalesce
+func (p *Parser) coalesceExpr() Expr {
+	l := p.rangeExpr()
+
+	n := p.cur()
+	if !(n.Type == OPERATOR && n.Data == "??") {
+		return l
+	}
+	p.consume()
+
+	return CoalesceExpr{
+		Left:  l,
+		Right: p.coalesceExpr(),
 	}
 }
 
@@ -270,6 +761,7 @@ func (p *Parser) addExpr() Expr {
 			Name:         op.Data,
 			IsArithmetic: true,
 			Args:         []Expr{l, r},
+			Pos:          p.pos(op),
 		}
 	}
 
@@ -278,13 +770,13 @@ func (p *Parser) addExpr() Expr {
 
 /// Multiplicative
 ///  : Literal
-///  | Multiplicative (*|/) Literal
+///  | Multiplicative (*|/|%) Literal
 func (p *Parser) mulExpr() Expr {
 	l := p.primaryExpr()
 
 	for {
 		n := p.cur()
-		if !(n.Type == OPERATOR && (n.Data == "*" || n.Data == "/")) {
+		if !(n.Type == OPERATOR && (n.Data == "*" || n.Data == "/" || n.Data == "%")) {
 			break
 		}
 
@@ -295,6 +787,7 @@ func (p *Parser) mulExpr() Expr {
 			Name:         op.Data,
 			IsArithmetic: true,
 			Args:         []Expr{l, r},
+			Pos:          p.pos(op),
 		}
 	}
 
@@ -304,25 +797,128 @@ func (p *Parser) mulExpr() Expr {
 func (p *Parser) primaryExpr() Expr {
 	n := p.cur()
 	if n.Type != OPERATOR {
-		return p.funcExpr()
+		return p.postfixExpr()
 	}
 
-	p.expectAny([]string{"-"})
+	p.expectAny([]string{"-", "&", "*", "|", "||"})
+	if n.Data == "|" || n.Data == "||" {
+		return p.lambda()
+	}
 	n = p.consume()
+
+	if n.Data == "&" {
+		return RefExpr{
+			Expr: p.primaryExpr(),
+		}
+	} else if n.Data == "*" {
+		return DerefExpr{
+			Expr: p.primaryExpr(),
+			Pos:  p.pos(n),
+		}
+	}
+
 	return FuncCall{
 		Name:         n.Data,
 		IsArithmetic: true,
 		Args:         []Expr{p.expr()},
+		Pos:          p.pos(n),
 	}
 }
 
-func (p *Parser) funcExpr() Expr {
-	p.expectAnyType([]TokenType{INT, HEX, FLOAT, STRING, BOOL, IDENT, LPAREN})
+func (p *Parser) postfixExpr() Expr {
+	e := p.funcExpr()
+
+	for {
+		n := p.cur()
+		if n.Type == LBRACKET {
+			p.consume()
+			index := p.nestedExpr()
+			p.expectAndConsume(RBRACKET, "")
+
+			e = IndexExpr{
+				Expr:  e,
+				Index: index,
+				Pos:   p.pos(n),
+			}
+		} else if n.Type == OPERATOR && (n.Data == "." || n.Data == "?.") {
+			p.consume()
+			e = p.fieldExpr(e, n.Data == "?.")
+		} else if n.Type == OPERATOR && n.Data == "?" {
+			p.consume()
+			e = TryExpr{
+				Expr: e,
+			}
+		} else if n.Type == LPAREN {
+			e = CallExpr{
+				Callee: e,
+				Args:   p.callArgs(),
+				Pos:    p.pos(n),
+			}
+		} else {
+			break
+		}
+	}
+
+	return e
+}
+
+func (p *Parser) fieldExpr(e Expr, optional bool) Expr {
+	p.expectAnyType([]TokenType{IDENT, INT, FLOAT})
 	t := p.consume()
 
-	switch t.Type {
-	case LPAREN:
-		return p.parenExpr(t)
+	// `t.0.1` is lexed as `t`, `.` and the float `0.1`.
+	if t.Type == FLOAT {
+		for k, field := range strings.Split(t.Data, ".") {
+			e = FieldExpr{
+				Expr:     e,
+				Field:    field,
+				Optional: optional && k == 0,
+				Pos:      p.pos(t),
+			}
+		}
+		return e
+	}
+
+	if t.Type == IDENT && p.cur().Type == LPAREN {
+		return MethodCall{
+			Receiver: e,
+			Method:   t.Data,
+			Args:     p.callArgs(),
+			Optional: optional,
+			Pos:      p.pos(t),
+		}
+	}
+
+	return FieldExpr{
+		Expr:     e,
+		Field:    t.Data,
+		Optional: optional,
+		Pos:      p.pos(t),
+	}
+}
+
+func (p *Parser) funcExpr() Expr {
+	p.expectAnyType([]TokenType{INT, HEX, FLOAT, STRING, ISTRING, CHAR, BOOL, NIL, IDENT, ITYPE, LPAREN, LBRACKET, KEYWORD})
+	t := p.cur()
+
+	if t.Type == KEYWORD {
+		p.expectAny([]string{"map", "match", "fun", "try"})
+		if t.Data == "match" {
+			return p.matchExpr()
+		} else if t.Data == "fun" {
+			return p.funcLit()
+		} else if t.Data == "try" {
+			return p.recoverExpr()
+		}
+		return p.mapLit()
+	}
+	p.consume()
+
+	switch t.Type {
+	case LPAREN:
+		return p.parenExpr(t)
+	case LBRACKET:
+		return p.arrayLit(t)
 	case INT:
 		fallthrough
 	case HEX:
@@ -331,35 +927,296 @@ func (p *Parser) funcExpr() Expr {
 		return p.floatLit(t)
 	case STRING:
 		return p.stringLit(t)
+	case ISTRING:
+		return p.interpStr(t)
+	case CHAR:
+		return p.charLit(t)
 	case BOOL:
 		return p.boolLit(t)
+	case NIL:
+		return NilLit{}
 	case IDENT:
 		return p.variableOrFuncCall(t)
+	case ITYPE:
+		// Conversion, e.g. `f64(n)`
+		return FuncCall{
+			Name: t.Data,
+			Args: p.callArgs(),
+			Pos:  p.pos(t),
+		}
 	}
 
 	panic("WHAT THE SHIT")
 }
 
-func (p *Parser) parenExpr(_ Token) ParenExpr {
-	e := p.expr()
+func (p *Parser) recoverExpr() RecoverExpr {
+	t := p.expectAndConsume(KEYWORD, "try")
+
+	call := p.postfixExpr()
+	switch c := call.(type) {
+	case FuncCall:
+		if c.IsArithmetic || c.IsComparison {
+			syntaxError("Expected a function call after try at %s\n", p.pos(t))
+		}
+	case MethodCall, CallExpr:
+	default:
+		syntaxError("Expected a function call after try at %s\n", p.pos(t))
+	}
+
+	return RecoverExpr{
+		Call: call,
+	}
+}
+
+func (p *Parser) funcLit() FuncLit {
+	p.expectAndConsume(KEYWORD, "fun")
+	params := p.funcParams()
+
+	var returnType TypeSpec
+	if n := p.cur(); n.Type == OPERATOR && n.Data == "->" {
+		p.consume()
+		returnType = p.typeSpec()
+	}
+
+	return FuncLit{
+		Params:     params,
+		ReturnType: returnType,
+		Body:       p.block(),
+	}
+}
+
+// lambda parses `|x, y: i32| x + y`, `|| { ... }` or `|x| -> i32 { ... }`.
+func (p *Parser) lambda() FuncLit {
+	lit := FuncLit{
+		Params:   make([]FuncParam, 0),
+		IsLambda: true,
+	}
+
+	// `||` is a lambda without parameters
+	if t := p.consume(); t.Data == "|" {
+		for {
+			if n := p.cur(); n.Type == OPERATOR && n.Data == "|" {
+				break
+			}
+
+			lit.Params = append(lit.Params, FuncParam{
+				Name: p.expectAndConsume(IDENT, "").Data,
+				Type: p.varType(),
+			})
 
+			if n := p.cur(); n.Type == OPERATOR && n.Data == "|" {
+				break
+			}
+
+			p.expectAndConsume(COMMA, "")
+		}
+		p.expectAndConsume(OPERATOR, "|")
+	}
+
+	if n := p.cur(); n.Type == OPERATOR && n.Data == "->" {
+		p.consume()
+		lit.ReturnType = p.typeSpec()
+		lit.Body = p.block()
+		return lit
+	}
+
+	if p.cur().Type == LCURLY {
+		lit.Body = p.block()
+		return lit
+	}
+
+	lit.IsExprBody = true
+	lit.Body = Block{
+		Stmts: []Stmt{
+			ReturnStmt{
+				Value: p.expr(),
+			},
+		},
+	}
+
+	return lit
+}
+
+func (p *Parser) parenExpr(_ Token) Expr {
+	e := p.nestedExpr()
+
+	if p.cur().Type != COMMA {
+		p.expectAndConsume(RPAREN, "")
+
+		return ParenExpr{
+			Expr: e,
+		}
+	}
+
+	// Tuple literal, `(a,)` is a tuple with a single element
+	elems := []Expr{e}
+	for p.cur().Type == COMMA {
+		p.consume()
+		if p.cur().Type == RPAREN {
+			break
+		}
+		elems = append(elems, p.nestedExpr())
+	}
 	p.expectAndConsume(RPAREN, "")
 
-	return ParenExpr{
-		Expr: e,
+	return TupleLit{
+		Elems: elems,
+	}
+}
+
+func (p *Parser) matchExpr() MatchExpr {
+	p.expectAndConsume(KEYWORD, "match")
+	subject := p.condExpr()
+	p.expectAndConsume(LCURLY, "")
+
+	arms := make([]MatchArm, 0)
+	for {
+		if p.cur().Type == RCURLY {
+			break
+		}
+
+		arm := MatchArm{
+			Pos:     p.pos(p.cur()),
+			Pattern: p.pattern(),
+		}
+
+		if n := p.cur(); n.Type == KEYWORD && n.Data == "if" {
+			p.consume()
+			arm.Guard = p.expr()
+		}
+
+		p.expectAndConsume(OPERATOR, "=>")
+
+		// Block arms may leave out the comma
+		if p.cur().Type == LCURLY {
+			arm.Body = p.block()
+			if p.cur().Type == COMMA {
+				p.consume()
+			}
+		} else {
+			arm.Body = p.expr()
+			if p.cur().Type != RCURLY {
+				p.expectAndConsume(COMMA, "")
+			}
+		}
+
+		arms = append(arms, arm)
+	}
+	end := p.expectAndConsume(RCURLY, "")
+
+	return MatchExpr{
+		Subject: subject,
+		Arms:    arms,
+		End:     p.pos(end),
+	}
+}
+
+func (p *Parser) pattern() Pattern {
+	p.expectAnyType([]TokenType{INT, HEX, FLOAT, STRING, CHAR, BOOL, IDENT, LPAREN})
+	t := p.cur()
+
+	switch t.Type {
+	case LPAREN:
+		p.consume()
+		elems := make([]Pattern, 0)
+		for {
+			elems = append(elems, p.pattern())
+
+			if p.cur().Type == RPAREN {
+				break
+			}
+
+			p.expectAndConsume(COMMA, "")
+		}
+		p.expectAndConsume(RPAREN, "")
+
+		if len(elems) == 1 {
+			return elems[0]
+		}
+
+		return TuplePattern{
+			Elems: elems,
+		}
+	case IDENT:
+		p.consume()
+		if t.Data == "_" {
+			return WildcardPattern{}
+		}
+
+		if n := p.cur(); !(n.Type == OPERATOR && n.Data == "::") {
+			return BindingPattern{
+				Name: t.Data,
+			}
+		}
+		p.consume()
+
+		variant := VariantPattern{
+			Enum:    t.Data,
+			Variant: p.expectAndConsume(IDENT, "").Data,
+			Fields:  make([]Pattern, 0),
+		}
+
+		if p.cur().Type == LPAREN {
+			p.consume()
+			for {
+				if p.cur().Type == RPAREN {
+					break
+				}
+
+				variant.Fields = append(variant.Fields, p.pattern())
+
+				if p.cur().Type == RPAREN {
+					break
+				}
+
+				p.expectAndConsume(COMMA, "")
+			}
+			p.expectAndConsume(RPAREN, "")
+		}
+
+		return variant
+	}
+
+	return LiteralPattern{
+		Value: p.funcExpr(),
 	}
 }
 
 func (p *Parser) variableOrFuncCall(t Token) Expr {
 	name := t.Data
 
+	// Qualified name, e.g. `Shape::Circle`
+	for {
+		n := p.cur()
+		if !(n.Type == OPERATOR && n.Data == "::") {
+			break
+		}
+		p.consume()
+
+		name += "::" + p.expectAndConsume(IDENT, "").Data
+	}
+
 	next := p.cur()
+	if next.Type == LCURLY && !p.noStructLit {
+		return p.structLit(t, name)
+	}
+
 	if next.Type != LPAREN {
 		return Variable{
 			Name: name,
+			Pos:  p.pos(t),
 		}
 	}
-	p.consume()
+
+	return FuncCall{
+		Name: name,
+		Args: p.callArgs(),
+		Pos:  p.pos(t),
+	}
+}
+
+func (p *Parser) callArgs() []Expr {
+	p.expectAndConsume(LPAREN, "")
 
 	args := make([]Expr, 0)
 
@@ -369,7 +1226,19 @@ func (p *Parser) variableOrFuncCall(t Token) Expr {
 			break
 		}
 
-		arg := p.expr()
+		// `name: value` passes the argument of the parameter called name
+		var arg Expr
+		if n.Type == IDENT && p.next().Type == OPERATOR && p.next().Data == ":" {
+			p.consume()
+			p.consume()
+			arg = NamedArg{
+				Name:  n.Data,
+				Value: p.nestedExpr(),
+				Pos:   p.pos(n),
+			}
+		} else {
+			arg = p.nestedExpr()
+		}
 		args = append(args, arg)
 
 		n = p.cur()
@@ -383,9 +1252,114 @@ func (p *Parser) variableOrFuncCall(t Token) Expr {
 	p.expect(RPAREN, "")
 	p.consume()
 
-	return FuncCall{
-		Name: name,
-		Args: args,
+	return args
+}
+
+func (p *Parser) arrayLit(t Token) ArrayLit {
+	elems := make([]Expr, 0)
+
+	for {
+		n := p.cur()
+		if n.Type == RBRACKET {
+			break
+		}
+
+		elem := p.nestedExpr()
+		elems = append(elems, elem)
+
+		n = p.cur()
+		if n.Type == RBRACKET {
+			break
+		}
+
+		p.expectAndConsume(COMMA, "")
+	}
+
+	p.expectAndConsume(RBRACKET, "")
+
+	return ArrayLit{
+		Elems: elems,
+		Pos:   p.pos(t),
+	}
+}
+
+func (p *Parser) mapLit() MapLit {
+	t := p.cur()
+	start := p.i
+	typ := p.mapType()
+	typ.Source = typeSource(p.tokens[start:p.i])
+
+	keys := make([]Expr, 0)
+	values := make([]Expr, 0)
+
+	p.expectAndConsume(LCURLY, "")
+	for {
+		n := p.cur()
+		if n.Type == RCURLY {
+			break
+		}
+
+		key := p.nestedExpr()
+		p.expectAndConsume(OPERATOR, ":")
+		value := p.nestedExpr()
+		keys = append(keys, key)
+		values = append(values, value)
+
+		n = p.cur()
+		if n.Type == RCURLY {
+			break
+		}
+
+		p.expectAndConsume(COMMA, "")
+	}
+	p.expectAndConsume(RCURLY, "")
+
+	return MapLit{
+		Type:   typ,
+		Keys:   keys,
+		Values: values,
+		Pos:    p.pos(t),
+	}
+}
+
+func (p *Parser) structLit(t Token, name string) StructLit {
+	p.expectAndConsume(LCURLY, "")
+
+	fields := make([]StructLitField, 0)
+	for {
+		if p.cur().Type == RCURLY {
+			break
+		}
+
+		field := p.expectAndConsume(IDENT, "")
+
+		// `Vec2 { x, y }` is short for `Vec2 { x: x, y: y }`
+		var value Expr = Variable{
+			Name: field.Data,
+			Pos:  p.pos(field),
+		}
+		if n := p.cur(); n.Type == OPERATOR && n.Data == ":" {
+			p.consume()
+			value = p.nestedExpr()
+		}
+
+		fields = append(fields, StructLitField{
+			Name:  field.Data,
+			Value: value,
+		})
+
+		if p.cur().Type == RCURLY {
+			break
+		}
+
+		p.expectAndConsume(COMMA, "")
+	}
+	p.expectAndConsume(RCURLY, "")
+
+	return StructLit{
+		Name:   name,
+		Fields: fields,
+		Pos:    p.pos(t),
 	}
 }
 
@@ -402,95 +1376,348 @@ func (p *Parser) stringLit(t Token) StrLit {
 
 	return StrLit{
 		Value: value,
+		Pos:   p.pos(t),
+	}
+}
+
+func (p *Parser) charLit(t Token) CharLit {
+	value, _ := utf8.DecodeRuneInString(t.Data)
+
+	return CharLit{
+		Value: value,
+		Pos:   p.pos(t),
+	}
+}
+
+// interpStr parses the embedded expressions of an interpolated string.
+func (p *Parser) interpStr(t Token) InterpStr {
+	parts := make([]Expr, 0)
+	for _, part := range t.Parts {
+		if part.Tokens == nil {
+			if part.Text != "" {
+				parts = append(parts, StrLit{Value: part.Text})
+			}
+			continue
+		}
+
+		sub := NewParser(part.Tokens)
+		sub.loc = p.loc
+		parts = append(parts, sub.expr())
+		sub.done()
+	}
+
+	return InterpStr{
+		Parts: parts,
+		Pos:   p.pos(t),
 	}
 }
 
 func (p *Parser) floatLit(t Token) FloatLit {
-	value, err := strconv.ParseFloat(t.Data, 64)
+	digits, suffix := splitSuffix(t.Data, floatTypes, false)
+	if suffix != "" && !contains(floatTypes, suffix) {
+		syntaxError("Invalid suffix %s on float literal at %s\n", suffix, p.pos(t))
+	}
+
+	value, err := strconv.ParseFloat(p.digits(t, digits), 64)
 	if err != nil {
-		log.Fatalf("Invalid float literal %s at %d\n", t.Data, p.i-1)
+		syntaxError("Invalid float literal %s at %s\n", t.Data, p.pos(t))
+	}
+
+	if suffix == "f32" && math.Abs(value) > math.MaxFloat32 {
+		syntaxError("Float literal %s overflows f32 at %s\n", t.Data, p.pos(t))
 	}
 
 	return FloatLit{
 		Value: value,
+		Type:  suffix,
+		Pos:   p.pos(t),
 	}
 }
 
 func (p *Parser) intLit(t Token) IntLit {
-	var value int
-	var err error = nil
-	if len(t.Data) > 2 {
-		// HEX
-		i := new(big.Int)
-		i.SetString(t.Data[2:], 16)
-		value = int(i.Int64())
-	} else {
-		value, err = strconv.Atoi(t.Data)
+	base := 10
+	digits := t.Data
+	switch {
+	case strings.HasPrefix(digits, "0x"):
+		base = 16
+	case strings.HasPrefix(digits, "0o"):
+		base = 8
+	case strings.HasPrefix(digits, "0b"):
+		base = 2
+	case len(digits) > 1 && digits[0] == '0' && unicode.IsDigit(rune(digits[1])):
+		syntaxError("Illegal integer %s at %s, leading zeros are not allowed\n", t.Data, p.pos(t))
+	}
+	if base != 10 {
+		digits = digits[2:]
 	}
 
-	if err != nil {
-		log.Fatalf("Invalid int literal %s at %d\n", t.Data, p.i-1)
+	digits, suffix := splitSuffix(digits, intTypes, base == 16)
+	if suffix != "" && !contains(intTypes, suffix) {
+		syntaxError("Invalid suffix %s on integer literal at %s\n", suffix, p.pos(t))
 	}
 
+	value, err := strconv.ParseUint(p.digits(t, digits), base, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		syntaxError("Integer literal %s overflows i64 at %s\n", t.Data, p.pos(t))
+	} else if err != nil {
+		syntaxError("Invalid int literal %s at %s\n", t.Data, p.pos(t))
+	}
+
+	// The checker reports literals that overflow their type, as `-128i8` and
+	// `var a: i8 = -128` are only known to fit once the minus is seen.
 	return IntLit{
-		Value: value,
+		Value: int(value),
+		Type:  suffix,
+		Pos:   p.pos(t),
 	}
 }
 
-func (p *Parser) varType() string {
+// intTypeMax holds the largest literal of each integer type. All integers are
+// 64 bit signed at runtime, so u64 literals are limited to the range of i64.
+var intTypeMax = map[string]uint64{
+	"u8": math.MaxUint8, "i8": math.MaxInt8,
+	"u16": math.MaxUint16, "i16": math.MaxInt16,
+	"u32": math.MaxUint32, "i32": math.MaxInt32,
+	"u64": math.MaxInt64, "i64": math.MaxInt64,
+}
+
+// splitSuffix splits the type suffix from the digits of a literal. The suffix
+// starts at the first letter that is not a digit in the literal's base.
+func splitSuffix(literal string, types []string, hex bool) (string, string) {
+	for _, typ := range types {
+		if strings.HasSuffix(literal, typ) && len(literal) > len(typ) {
+			return literal[:len(literal)-len(typ)], typ
+		}
+	}
+
+	for k, r := range literal {
+		if unicode.IsLetter(r) && !(hex && strings.ContainsRune("abcdefABCDEF", r)) {
+			return literal[:k], literal[k:]
+		}
+	}
+
+	return literal, ""
+}
+
+// digits removes the `_` separators from the digits of a literal. A separator
+// must stand between two digits.
+func (p *Parser) digits(t Token, digits string) string {
+	if digits == "" || strings.HasPrefix(digits, "_") || strings.HasSuffix(digits, "_") ||
+		strings.Contains(digits, "__") || strings.Contains(digits, "_.") || strings.Contains(digits, "._") {
+		syntaxError("Invalid numeric literal %s at %s\n", t.Data, p.pos(t))
+	}
+
+	return strings.ReplaceAll(digits, "_", "")
+}
+
+func (p *Parser) varType() TypeSpec {
 	// Possible type
 	pt := p.cur()
-	typ := ""
 	if pt.Type == OPERATOR && pt.Data == ":" {
 		p.consume()
+		return p.typeSpec()
+	}
 
-		isRef := false
-		// Possible reference type
-		prt := p.cur()
-		if prt.Type == OPERATOR && prt.Data == "&" {
-			isRef = true
-			if isRef {
-				typ = "&"
-			}
+	return TypeSpec{}
+}
+
+func (p *Parser) typeSpec() TypeSpec {
+	start := p.i
+	isRef := false
+	// Possible reference type
+	prt := p.cur()
+	if prt.Type == OPERATOR && prt.Data == "&" {
+		isRef = true
+		p.consume()
+	}
+
+	var typ TypeSpec
+	t := p.cur()
+	if t.Type == LBRACKET {
+		p.consume()
+		p.expectAndConsume(RBRACKET, "")
+		elem := p.typeSpec()
+
+		typ = TypeSpec{
+			Kind:  SliceType,
+			Elems: []TypeSpec{elem},
+		}
+	} else if t.Type == KEYWORD && t.Data == "map" {
+		typ = p.mapType()
+	} else if t.Type == LPAREN {
+		typ = p.tupleType()
+	} else if t.Type == KEYWORD && t.Data == "dyn" {
+		p.consume()
+		typ = TypeSpec{
+			Kind: DynType,
+			Name: p.expectAndConsume(IDENT, "").Data,
+		}
+	} else if t.Type == KEYWORD && t.Data == "fun" {
+		typ = p.funcType()
+	} else if t.Type == IDENT && t.Data == "Self" {
+		p.consume()
+		typ = p.selfTypeSpec()
+	} else {
+		p.expectAnyType([]TokenType{ITYPE, IDENT})
+		name := p.consume()
+		typ = NamedTypeSpec(name.Data)
+		if alias, ok := p.alias(name); ok {
+			typ = alias
+		}
+
+		// Type arguments, e.g. `Pair<i32, str>`
+		if n := p.cur(); n.Type == OPERATOR && n.Data == "<" {
 			p.consume()
+			for {
+				typ.Elems = append(typ.Elems, p.typeSpec())
+
+				if n := p.cur(); n.Type == OPERATOR && n.Data == ">" {
+					break
+				}
+
+				p.expectAndConsume(COMMA, "")
+			}
+			p.expectAndConsume(OPERATOR, ">")
 		}
+	}
 
-		p.expectAnyType([]TokenType{ITYPE, IDENT})
-		t := p.consume()
-		typ += t.Data
+	// `&(i32?)` refers to a nullable value
+	if isRef {
+		typ = RefTo(typ)
+	}
+
+	// Nullable type, e.g. `i32?`, `&Node?` is a reference that may be nil
+	if n := p.cur(); n.Type == OPERATOR && n.Data == "?" {
+		p.consume()
+		typ.IsNullable = true
 	}
 
+	// `T!E` is short for `Result<T, E>`
+	if n := p.cur(); n.Type == OPERATOR && n.Data == "!" {
+		p.consume()
+		typ = TypeSpec{
+			Kind:  NamedType,
+			Name:  "Result",
+			Elems: []TypeSpec{typ, p.typeSpec()},
+		}
+	}
+
+	typ.Source = typeSource(p.tokens[start:p.i])
 	return typ
 }
 
-func (p *Parser) funcDecl() FuncDecl {
-	t := p.expectAndConsume(IDENT, "")
+// typeSource writes the tokens of a type with canonical spacing, e.g.
+// `fun(i32, str) -> bool`.
+func typeSource(tokens []Token) string {
+	sb := strings.Builder{}
+	for _, t := range tokens {
+		switch {
+		case t.Type == COMMA:
+			sb.WriteString(", ")
+		case t.Data == "->":
+			sb.WriteString(" -> ")
+		case t.Type == KEYWORD && t.Data == "dyn":
+			sb.WriteString("dyn ")
+		default:
+			sb.WriteString(t.Data)
+		}
+	}
 
-	name := t.Data
-	returnType := ""
+	return sb.String()
+}
+
+// selfTypeSpec is the type `Self` stands for. Outside of an impl block, like
+// in a trait, it stays `Self` until the trait is implemented for some type.
+func (p *Parser) selfTypeSpec() TypeSpec {
+	if p.selfType.Kind == NoType {
+		return NamedTypeSpec("Self")
+	}
 
+	return p.selfType
+}
+
+func (p *Parser) tupleType() TypeSpec {
 	p.expectAndConsume(LPAREN, "")
 
-	params := make([]FuncParam, 0)
+	elems := make([]TypeSpec, 0)
 	for {
-		n := p.cur()
-		if n.Type == RPAREN {
+		elems = append(elems, p.typeSpec())
+
+		if p.cur().Type == RPAREN {
 			break
 		}
 
-		param := p.funcParam()
-		params = append(params, param)
+		p.expectAndConsume(COMMA, "")
+	}
+	p.expectAndConsume(RPAREN, "")
 
-		n = p.cur()
-		if n.Type == RPAREN {
+	// A parenthesized type is just the type itself
+	if len(elems) == 1 {
+		return elems[0]
+	}
+
+	return TypeSpec{
+		Kind:  TupleType,
+		Elems: elems,
+	}
+}
+
+// funcType parses a function type like `fun(i32, str) -> bool`.
+func (p *Parser) funcType() TypeSpec {
+	p.expectAndConsume(KEYWORD, "fun")
+	p.expectAndConsume(LPAREN, "")
+
+	params := make([]TypeSpec, 0)
+	for p.cur().Type != RPAREN {
+		params = append(params, p.typeSpec())
+
+		if p.cur().Type == RPAREN {
 			break
 		}
 
 		p.expectAndConsume(COMMA, "")
 	}
-
 	p.expectAndConsume(RPAREN, "")
 
+	var ret TypeSpec
+	if n := p.cur(); n.Type == OPERATOR && n.Data == "->" {
+		p.consume()
+		ret = p.typeSpec()
+	}
+
+	return FuncTypeSpec(params, ret)
+}
+
+func (p *Parser) mapType() TypeSpec {
+	p.expectAndConsume(KEYWORD, "map")
+	p.expectAndConsume(LBRACKET, "")
+	key := p.typeSpec()
+	p.expectAndConsume(RBRACKET, "")
+	value := p.typeSpec()
+
+	return TypeSpec{
+		Kind:  MapType,
+		Elems: []TypeSpec{key, value},
+	}
+}
+
+func (p *Parser) funcDecl(t Token) FuncDecl {
+	decl := p.funcSignature()
+	decl.Body = p.block()
+	decl.Pos = p.pos(t)
+
+	return decl
+}
+
+func (p *Parser) funcSignature() FuncDecl {
+	t := p.expectAndConsume(IDENT, "")
+
+	name := t.Data
+	var returnType TypeSpec
+
+	typeParams := p.typeParams()
+	params := p.funcParams()
+
 	// Possible return type
 	prt := p.cur()
 	if prt.Type == OPERATOR {
@@ -498,25 +1725,51 @@ func (p *Parser) funcDecl() FuncDecl {
 
 		p.consume()
 
-		p.expectAnyType([]TokenType{ITYPE, IDENT})
-
-		rt := p.consume()
-		returnType = rt.Data
+		returnType = p.typeSpec()
 	}
 
-	p.globalSpace = true
-	body := p.block()
-	p.globalSpace = false
-
 	return FuncDecl{
 		Name:       name,
+		TypeParams: typeParams,
 		ReturnType: returnType,
 		Params:     params,
-		Body:       body,
 	}
 }
 
+func (p *Parser) funcParams() []FuncParam {
+	p.expectAndConsume(LPAREN, "")
+
+	params := make([]FuncParam, 0)
+	for {
+		n := p.cur()
+		if n.Type == RPAREN {
+			break
+		}
+
+		param := p.funcParam()
+		params = append(params, param)
+
+		n = p.cur()
+		if n.Type == RPAREN {
+			break
+		}
+
+		p.expectAndConsume(COMMA, "")
+	}
+
+	p.expectAndConsume(RPAREN, "")
+
+	return params
+}
+
 func (p *Parser) block() Block {
+	// Declarations in a block are local, also in function literals of global initializers
+	global := p.globalSpace
+	p.globalSpace = false
+	defer func() {
+		p.globalSpace = global
+	}()
+
 	stmts := make([]Stmt, 0)
 	p.expectAndConsume(LCURLY, "")
 
@@ -530,30 +1783,64 @@ func (p *Parser) block() Block {
 		stmts = append(stmts, stmt)
 	}
 
-	p.expectAndConsume(RCURLY, "")
+	end := p.expectAndConsume(RCURLY, "")
 
 	return Block{
 		Stmts: stmts,
+		End:   p.pos(end),
 	}
 }
 
 func (p *Parser) funcParam() FuncParam {
+	// `self` and `&self` receive the value a method is called on
+	isRef := false
+	if n := p.cur(); n.Type == OPERATOR && n.Data == "&" {
+		p.consume()
+		isRef = true
+		p.expect(IDENT, "self")
+	}
+
+	if n := p.cur(); n.Type == IDENT && n.Data == "self" && (isRef || p.next().Data != ":") {
+		p.consume()
+		typ := p.selfTypeSpec()
+		typ.IsRef = isRef
+		typ.Source = ""
+
+		return FuncParam{
+			Name: "self",
+			Type: typ,
+		}
+	}
+
 	n := p.expectAndConsume(IDENT, "")
-	name := n.Data
+	param := FuncParam{
+		Name: n.Data,
+	}
 	p.expectAndConsume(OPERATOR, ":")
-	p.expectAnyType([]TokenType{ITYPE, IDENT})
-	n = p.consume()
-	typ := n.Data
 
-	return FuncParam{
-		Name: name,
-		Type: typ,
+	// `xs: ...i32` receives the remaining arguments as a `[]i32`
+	if n := p.cur(); n.Type == OPERATOR && n.Data == "..." {
+		p.consume()
+		param.IsVariadic = true
+		param.Type = TypeSpec{
+			Kind:  SliceType,
+			Elems: []TypeSpec{p.typeSpec()},
+		}
+	} else {
+		param.Type = p.typeSpec()
+	}
+
+	if n := p.cur(); n.Type == OPERATOR && n.Data == "=" {
+		p.consume()
+		param.Default = p.nestedExpr()
 	}
+
+	return param
 }
 
 func (p *Parser) locStmt() LocStmt {
 	p.expect(KEYWORD, "loc")
-	p.consume()
+	t := p.consume()
 
 	sb := strings.Builder{}
 	for {
@@ -573,11 +1860,21 @@ func (p *Parser) locStmt() LocStmt {
 	p.expect(SEMI, ";")
 	p.consume()
 
+	p.loc = sb.String()
+
 	return LocStmt{
 		Value: sb.String(),
+		Pos:   p.pos(t),
 	}
 }
 
+// pos returns the position of a token in the program.
+func (p *Parser) pos(t Token) Pos {
+	pos := t.Pos
+	pos.File = p.loc
+	return pos
+}
+
 func (p *Parser) isOfAnyType(typ []TokenType) bool {
 	t := p.cur()
 	for _, ty := range typ {
@@ -597,12 +1894,12 @@ func (p *Parser) expectAndConsume(typ TokenType, value string) Token {
 func (p *Parser) expect(typ TokenType, value string) {
 	token := p.cur()
 	if token.Type != typ {
-		log.Fatalf("Expected type %s, but got type %s at %d\n", Name(typ), token.Name(), p.i)
+		syntaxError("Expected type %s, but got type %s at %s\n", Name(typ), token.Name(), p.pos(token))
 	}
 
 	if len(value) > 0 {
 		if value != token.Data {
-			log.Fatalf("Expected %s, but got %s at %d\n", value, token.Data, p.i)
+			syntaxError("Expected %s, but got %s at %s\n", value, token.Data, p.pos(token))
 		}
 	}
 }
@@ -618,7 +1915,7 @@ func (p *Parser) expectAnyType(typs []TokenType) {
 	typsStr := strings.Join(Map(typs, func(t TokenType) string {
 		return Name(t)
 	}), ", ")
-	log.Fatalf("Expected one of types %s, but got type %s at %d\n", typsStr, token.Name(), p.i)
+	syntaxError("Expected one of types %s, but got type %s at %s\n", typsStr, token.Name(), p.pos(token))
 }
 
 func (p *Parser) expectAny(values []string) {
@@ -630,7 +1927,7 @@ func (p *Parser) expectAny(values []string) {
 	}
 
 	vStr := strings.Join(values, ", ")
-	log.Fatalf("Expected one of %s, but got %s at %d\n", vStr, token.Name(), p.i)
+	syntaxError("Expected one of %s, but got %s at %s\n", vStr, token.Name(), p.pos(token))
 }
 
 func (p *Parser) done() {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pattern is the left-hand side of a match arm. Patterns are not visited like
+// other nodes, because matching needs the value that is being matched.
+type Pattern interface {
+	String() string
+	patternNode()
+}
+
+// Binding pattern, matches anything and binds it to a variable
+
+type BindingPattern struct {
+	Name string
+}
+
+func (b BindingPattern) String() string {
+	return fmt.Sprintf("BindingPattern(%s)", b.Name)
+}
+
+func (b BindingPattern) patternNode() {}
+
+// Literal pattern
+
+type LiteralPattern struct {
+	Value Expr
+}
+
+func (l LiteralPattern) String() string {
+	return fmt.Sprintf("LiteralPattern(%s)", l.Value.String())
+}
+
+func (l LiteralPattern) patternNode() {}
+
+// Tuple pattern
+
+type TuplePattern struct {
+	Elems []Pattern
+}
+
+func (t TuplePattern) String() string {
+	return fmt.Sprintf("TuplePattern(%s)", joinPatterns(t.Elems))
+}
+
+func (t TuplePattern) patternNode() {}
+
+// Variant pattern, e.g. `Shape::Rect(w, h)`
+
+type VariantPattern struct {
+	Enum    string
+	Variant string
+	Fields  []Pattern
+}
+
+func (v VariantPattern) String() string {
+	return fmt.Sprintf("VariantPattern(%s::%s, %s)", v.Enum, v.Variant, joinPatterns(v.Fields))
+}
+
+func (v VariantPattern) patternNode() {}
+
+// Wildcard pattern `_`
+
+type WildcardPattern struct{}
+
+func (w WildcardPattern) String() string {
+	return "WildcardPattern"
+}
+
+func (w WildcardPattern) patternNode() {}
+
+func joinPatterns(patterns []Pattern) string {
+	return strings.Join(Map(patterns, func(p Pattern) string {
+		return p.String()
+	}), ", ")
+}
+
+// isIrrefutable reports whether a pattern matches every value.
+func isIrrefutable(pattern Pattern) bool {
+	switch pattern.(type) {
+	case WildcardPattern, BindingPattern:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// prelude declares the types every program can use, like Result and Error.
+//
+//go:embed prelude.ava
+var prelude string
+
+// withPrelude puts the declarations of the prelude in front of the program.
+func withPrelude(tree ProgStmt) ProgStmt {
+	glbls := CreateAst(strings.NewReader(prelude)).Glbls
+	tree.Glbls = append(glbls, tree.Glbls...)
+
+	return tree
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const replHelp = `Type declarations, statements or expressions. Expressions print their value.
+	:type <expr> - prints the type of an expression
+	:ast <input> - prints the syntax tree of the input
+	:load <file> - adds the declarations of a file
+	:help - prints this help message
+	:quit - leaves the REPL`
+
+// Repl reads input line by line and runs it. Declarations are kept, so the
+// input builds up a program one piece at a time.
+type Repl struct {
+	interp *Interp
+	// glbls holds the prelude and the declarations entered so far.
+	glbls []GlblStmt
+	// aliases holds the type aliases entered so far, which the parser resolves.
+	aliases map[string]TypeSpec
+
+	out io.Writer
+}
+
+func NewRepl(out io.Writer) *Repl {
+	tree := withPrelude(ProgStmt{
+		Loc: LocStmt{
+			Value: "repl",
+		},
+	})
+
+	interp := newInterp(tree)
+	interp.VisitProgStmt(tree)
+
+	return &Repl{
+		interp:  interp,
+		glbls:   tree.Glbls,
+		aliases: make(map[string]TypeSpec),
+		out:     out,
+	}
+}
+
+func runRepl() {
+	fmt.Println("Ava REPL, type :help for help")
+
+	repl := NewRepl(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+
+	input := ""
+	for {
+		if input == "" {
+			fmt.Print("ava> ")
+		} else {
+			fmt.Print("...> ")
+		}
+
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		input += scanner.Text() + "\n"
+
+		// Input with unclosed braces continues on the next line
+		if incomplete(input) {
+			continue
+		}
+
+		if strings.TrimSpace(input) == ":quit" {
+			return
+		}
+		repl.Eval(input)
+		input = ""
+	}
+}
+
+// incomplete reports whether input has unclosed braces, brackets or
+// parentheses, or ends inside of a multi-line string.
+func incomplete(input string) (open bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(SyntaxError); !ok {
+				panic(r)
+			}
+			open = strings.Count(input, `"""`)%2 == 1
+		}
+	}()
+
+	depth := 0
+	for _, t := range NewLexer(strings.NewReader(input)).ReadAllTokens() {
+		switch t.Type {
+		case LPAREN, LCURLY, LBRACKET:
+			depth++
+		case RPAREN, RCURLY, RBRACKET:
+			depth--
+		}
+	}
+
+	return depth > 0
+}
+
+// Eval runs one input, which is either a meta-command or Ava source.
+func (r *Repl) Eval(input string) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return
+	}
+
+	command, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch command {
+	case ":help":
+		fmt.Fprintln(r.out, replHelp)
+	case ":type":
+		r.printType(arg)
+	case ":ast":
+		r.printAst(arg)
+	case ":load":
+		r.load(arg)
+	default:
+		if strings.HasPrefix(command, ":") {
+			fmt.Fprintf(r.out, "Unknown command %s, type :help for help\n", command)
+			return
+		}
+		r.run(input)
+	}
+}
+
+// parse parses input as declarations and statements. A missing semicolon
+// after the last statement is added, so expressions can be typed as they are.
+func (r *Repl) parse(input string) ([]GlblStmt, []Stmt, error) {
+	decls, stmts, err := r.parseTokens(input)
+	if err != nil {
+		decls, stmts, semiErr := r.parseTokens(input + ";")
+		if semiErr == nil {
+			return decls, stmts, nil
+		}
+	}
+
+	return decls, stmts, err
+}
+
+func (r *Repl) parseTokens(input string) (decls []GlblStmt, stmts []Stmt, err error) {
+	defer catchSyntaxError(&err)
+
+	p := NewParser(NewLexer(strings.NewReader(input)).ReadAllTokens())
+	p.loc = "repl"
+	p.scanAliases()
+	for name, typ := range r.aliases {
+		if _, ok := p.aliases[name]; !ok {
+			p.aliases[name] = typ
+		}
+	}
+
+	for p.cur().Type != EOF {
+		if p.cur().Data == "var" && p.next().Type == LPAREN {
+			decls = append(decls, p.stmt().(TupleVarDecl))
+		} else if isReplDecl(p) {
+			decls = append(decls, p.glblStmt())
+		} else {
+			stmts = append(stmts, p.stmt())
+		}
+	}
+
+	// Aliases are kept for the next inputs
+	for name := range p.aliases {
+		if typ, ok := p.alias(Token{Data: name}); ok {
+			r.aliases[name] = typ
+		}
+	}
+
+	return decls, stmts, nil
+}
+
+// isReplDecl reports whether the parser is at a declaration, which is kept
+// for the next inputs. Variables are declared as globals, so they are kept too.
+func isReplDecl(p *Parser) bool {
+	t := p.cur()
+	if t.Type != KEYWORD {
+		return false
+	}
+
+	switch t.Data {
+	case "fun", "struct", "enum", "trait", "impl", "type", "const", "var":
+		return true
+	}
+
+	return false
+}
+
+func (r *Repl) run(input string) {
+	decls, stmts, err := r.parse(input)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+
+	if len(decls) > 0 && !r.declare(decls) {
+		return
+	}
+	if len(stmts) == 0 {
+		return
+	}
+
+	checker := r.checker(r.glbls)
+	checker.Check()
+	typ, errs := checker.checkInput(stmts)
+	if len(errs) > 0 {
+		r.printErrors(errs)
+		return
+	}
+
+	val, ok := r.catchPanic(func() AvaVal {
+		return r.interp.runInput(stmts)
+	})
+	if ok && !isVoid(typ) {
+		fmt.Fprintln(r.out, deref(val))
+	}
+}
+
+// declare adds declarations to the program, replacing earlier ones of the
+// same name. It reports whether they were free of errors. Variables declared
+// like `var (a, b) = f();` are added after the other declarations.
+func (r *Repl) declare(decls []GlblStmt) bool {
+	var tuples []TupleVarDecl
+	decls = Filter(decls, func(decl GlblStmt) bool {
+		tuple, ok := decl.(TupleVarDecl)
+		if ok {
+			tuples = append(tuples, tuple)
+		}
+		return !ok
+	})
+
+	if len(decls) > 0 && !r.declareGlobals(decls) {
+		return false
+	}
+	for _, tuple := range tuples {
+		if !r.declareTuple(tuple) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// replace returns the declarations of the program with decls added, in place
+// of the earlier ones of the same name.
+func (r *Repl) replace(decls []GlblStmt) []GlblStmt {
+	glbls := make([]GlblStmt, 0, len(r.glbls)+len(decls))
+	for _, glbl := range r.glbls {
+		replaced := false
+		for _, decl := range decls {
+			replaced = replaced || declKey(glbl) == declKey(decl)
+		}
+		if !replaced {
+			glbls = append(glbls, glbl)
+		}
+	}
+
+	return append(glbls, decls...)
+}
+
+func (r *Repl) declareGlobals(decls []GlblStmt) bool {
+	glbls := r.replace(decls)
+	if errs := r.checkRedefined(decls, glbls); len(errs) > 0 {
+		r.printErrors(errs)
+		return false
+	}
+
+	checker := r.checker(glbls)
+	if errs := checker.Check(); len(errs) > 0 {
+		r.printErrors(errs)
+		return false
+	}
+
+	// Globals are initialized in the order they depend on each other, once
+	// the functions they may call are declared.
+	_, ok := r.catchPanic(func() AvaVal {
+		for _, decl := range decls {
+			// The interpreter refuses to redefine a type, the old definition
+			// goes first
+			switch decl := decl.(type) {
+			case StructDecl:
+				delete(r.interp.structs, decl.Name)
+			case EnumDecl:
+				delete(r.interp.enums, decl.Name)
+			}
+
+			if _, isGlobal := globalName(decl); !isGlobal {
+				r.interp.Visit(decl)
+			}
+		}
+		for _, glbl := range checker.initOrder {
+			for _, decl := range decls {
+				if declKey(glbl) == declKey(decl) {
+					r.interp.Visit(glbl)
+				}
+			}
+		}
+		return AvaVal{}
+	})
+
+	// A global whose initializer panics is left out, so it stays undefined
+	if !ok {
+		return false
+	}
+
+	// Initialized globals keep the type they were checked with, their values
+	// do not change when a function of the initializer is redefined.
+	for k, glbl := range glbls {
+		if decl, isVar := glbl.(VarDecl); isVar && decl.Init != nil {
+			glbls[k] = VarDecl{
+				Name: decl.Name,
+				Type: checker.environment.Get(decl.Name).Type,
+				Pos:  decl.Pos,
+			}
+		}
+	}
+	r.glbls = glbls
+
+	return true
+}
+
+// checkRedefined reports types that decls redefine while the globals in glbls
+// still hold values of the old definition.
+func (r *Repl) checkRedefined(decls []GlblStmt, glbls []GlblStmt) []string {
+	errs := make([]string, 0)
+	for _, decl := range decls {
+		name, isType := strings.CutPrefix(declKey(decl), "type ")
+		if !isType || !r.redefines(decl) {
+			continue
+		}
+
+		for _, glbl := range glbls {
+			if v, isVar := glbl.(VarDecl); isVar && v.Init == nil && mentionsType(v.Type, name) {
+				errs = append(errs, fmt.Sprintf("Cannot redefine type %s, global %s holds a value of it", name, v.Name))
+			}
+		}
+	}
+
+	return errs
+}
+
+// redefines reports whether decl replaces a different declaration of the same
+// name.
+func (r *Repl) redefines(decl GlblStmt) bool {
+	for _, glbl := range r.glbls {
+		if declKey(glbl) == declKey(decl) {
+			return glbl.String() != decl.String()
+		}
+	}
+
+	return false
+}
+
+// mentionsType reports whether typ or one of its element types is named name.
+func mentionsType(typ TypeSpec, name string) bool {
+	if typ.Name == name {
+		return true
+	}
+
+	for _, elem := range typ.Elems {
+		if mentionsType(elem, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// declareTuple runs `var (a, b) = f();` in the global scope. Its variables are
+// kept as globals of the types of the tuple elements.
+func (r *Repl) declareTuple(tuple TupleVarDecl) bool {
+	checker := r.checker(r.glbls)
+	checker.Check()
+	types, errs := checker.checkTupleInput(tuple)
+	if len(errs) > 0 {
+		r.printErrors(errs)
+		return false
+	}
+
+	decls := make([]GlblStmt, len(tuple.Names))
+	for k, name := range tuple.Names {
+		decls[k] = VarDecl{
+			Name: name,
+			Type: types[k],
+			Pos:  tuple.Pos,
+		}
+	}
+
+	glbls := r.replace(decls)
+	if errs := r.checker(glbls).Check(); len(errs) > 0 {
+		r.printErrors(errs)
+		return false
+	}
+
+	_, ok := r.catchPanic(func() AvaVal {
+		return r.interp.Visit(tuple)
+	})
+	if ok {
+		r.glbls = glbls
+	}
+	return ok
+}
+
+// declKey tells declarations apart, a new declaration replaces the one with
+// the same key.
+func declKey(glbl GlblStmt) string {
+	switch decl := glbl.(type) {
+	case FuncDecl:
+		return "fun " + decl.Name
+	case StructDecl:
+		return "type " + decl.Name
+	case EnumDecl:
+		return "type " + decl.Name
+	case TypeDecl:
+		return "type " + decl.Name
+	case TraitDecl:
+		return "trait " + decl.Name
+	case ImplDecl:
+		return fmt.Sprintf("impl %s for %s", decl.Trait, decl.Target.Name)
+	case VarDecl:
+		return "var " + decl.Name
+	case ConstDecl:
+		return "var " + decl.Name
+	}
+
+	return glbl.String()
+}
+
+func (r *Repl) checker(glbls []GlblStmt) *Checker {
+	return NewChecker(ProgStmt{
+		Loc:   r.interp.tree.Loc,
+		Glbls: glbls,
+	})
+}
+
+func (r *Repl) printType(input string) {
+	_, stmts, err := r.parse(input)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	} else if len(stmts) != 1 {
+		fmt.Fprintln(r.out, ":type expects a single expression")
+		return
+	}
+
+	stmt, ok := stmts[0].(ExprStmt)
+	if !ok {
+		fmt.Fprintln(r.out, ":type expects an expression")
+		return
+	}
+
+	checker := r.checker(r.glbls)
+	checker.Check()
+	typ, errs := checker.checkInput([]Stmt{stmt})
+	if len(errs) > 0 {
+		r.printErrors(errs)
+		return
+	}
+
+	fmt.Fprintln(r.out, typ)
+}
+
+func (r *Repl) printAst(input string) {
+	decls, stmts, err := r.parse(input)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+
+	for _, decl := range decls {
+		fmt.Fprintln(r.out, decl)
+	}
+	for _, stmt := range stmts {
+		fmt.Fprintln(r.out, stmt)
+	}
+}
+
+// load adds the declarations of a file. Its main function is declared, but
+// not run.
+func (r *Repl) load(path string) {
+	file, err := getFile(path)
+	if err != nil {
+		fmt.Fprintf(r.out, "Error opening file %s: %v\n", path, err)
+		return
+	}
+	defer file.Close()
+
+	tree, err := parseProgram(file)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+
+	if r.declare(tree.Glbls) {
+		fmt.Fprintf(r.out, "Loaded %d declarations from %s\n", len(tree.Glbls), path)
+	}
+}
+
+// catchPanic runs f and prints a panic it does not catch, instead of
+// ending the REPL.
+func (r *Repl) catchPanic(f func() AvaVal) (val AvaVal, ok bool) {
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+
+		err, isRuntime := rec.(RuntimeError)
+		if !isRuntime {
+			panic(rec)
+		}
+
+		r.interp.callStack = nil
+		r.interp.returnValue = nil
+		printPanic(r.out, err)
+		ok = false
+	}()
+
+	return f(), true
+}
+
+func (r *Repl) printErrors(errs []string) {
+	for _, err := range errs {
+		fmt.Fprintln(r.out, err)
+	}
+}
+
+// checkInput checks statements typed into the REPL. They run like the body
+// of a function in the scope of the globals. It returns the type of the last
+// statement when it is an expression.
+func (c *Checker) checkInput(stmts []Stmt) (TypeSpec, []string) {
+	c.returnType = anyType
+	c.environment.EnterBlock()
+	defer c.environment.ExitBlock()
+
+	typ := voidType
+	for k, stmt := range stmts {
+		if expr, ok := stmt.(ExprStmt); ok && k == len(stmts)-1 {
+			typ = c.typeOf(expr.Expr)
+		} else {
+			c.Visit(stmt)
+		}
+	}
+
+	return typ, c.errors
+}
+
+// checkTupleInput checks `var (a, b) = f();` typed into the REPL and returns
+// the types of its variables.
+func (c *Checker) checkTupleInput(tuple TupleVarDecl) ([]TypeSpec, []string) {
+	c.returnType = anyType
+	c.environment.EnterBlock()
+	defer c.environment.ExitBlock()
+
+	c.Visit(tuple)
+	types := Map(tuple.Names, func(name string) TypeSpec {
+		return c.environment.Get(name).Type
+	})
+
+	return types, c.errors
+}
+
+// runInput runs statements typed into the REPL and returns the value of the
+// last one when it is an expression.
+func (i *Interp) runInput(stmts []Stmt) AvaVal {
+	body := append([]Stmt{}, stmts...)
+	if expr, ok := body[len(body)-1].(ExprStmt); ok {
+		body[len(body)-1] = ReturnStmt{
+			Value: expr.Expr,
+		}
+	}
+
+	return i.runFunction(FunctionDefinition{
+		Name: "repl",
+		Body: Block{
+			Stmts: body,
+		},
+		Env: i.environment,
+	}, nil)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "fmt"
+
+// RuntimeError is panicked when an Ava program fails while it runs. It
+// unwinds to the closest `try`, or ends the program with a stack trace.
+type RuntimeError struct {
+	Message string
+	// Trace is the call stack where the panic started.
+	Trace []stackFrame
+}
+
+func runtimePanic(format string, args ...any) {
+	panic(RuntimeError{
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// stackFrame is a call of an Ava function, Pos is where the function is at.
+type stackFrame struct {
+	Function string
+	Pos      Pos
+	// Deferred holds the expressions to run when the function returns.
+	Deferred []deferredExpr
+}
+
+// deferredExpr is deferred by `defer`. It runs in the scopes it was deferred
+// in and sees the values the variables have when it runs.
+type deferredExpr struct {
+	Expr Expr
+	Env  *Environment[*AvaVar]
+}
+
+func (f stackFrame) String() string {
+	return fmt.Sprintf("at %s (%s)", f.Function, f.Pos)
+}
@@ -1,5 +1,6 @@
 package main
 
 type StructDefinition struct {
-	Name string
+	Name   string
+	Fields []StructField
 }
@@ -4,35 +4,76 @@ import subprocess
 tests_run = 0
 tests_passed = 0
 
-def run_test(file_path):
+def read_file(file_path):
+    with open(file_path, "r", encoding="UTF-8") as file:
+        return file.read()
+
+def check(name, expected_output, output):
     global tests_run, tests_passed
 
     tests_run += 1
 
-    expected_output = ""
-    with open(file_path, "r", encoding="UTF-8") as file:
-        expected_output = file.read()
-
-    source_path = file_path.split(".")[0] + ".ava"
-    result = subprocess.run(["go", "run", ".", "run", source_path], stdout=subprocess.PIPE)
-    output = result.stdout.decode("utf-8")
-
     passed = expected_output == output
 
     if passed:
         tests_passed += 1
-        print(f"{source_path}: PASS")
+        print(f"{name}: PASS")
     else:
-        print(f"{source_path}: FAIL")
+        print(f"{name}: FAIL")
         print(f"`{expected_output}` != `{output}`")
 
+def run_test(file_path):
+    expected_output = read_file(file_path)
+
+    source_path = file_path.split(".")[0] + ".ava"
+    result = subprocess.run(["go", "run", ".", "run", source_path], stdout=subprocess.PIPE)
+    output = result.stdout.decode("utf-8")
+
+    check(source_path, expected_output, output)
+
+# run_fmt_test formats a source file and compares the result with the .txt
+# file next to it. Formatting the expected output again must not change it.
+def run_fmt_test(file_path):
+    expected_output = read_file(file_path)
+
+    source_path = file_path.split(".")[0] + ".ava"
+    result = subprocess.run(["go", "run", ".", "fmt", source_path], stdout=subprocess.PIPE)
+    output = result.stdout.decode("utf-8")
+
+    check(source_path, expected_output, output)
+
+    result = subprocess.run(["go", "run", ".", "fmt", file_path], stdout=subprocess.PIPE)
+    output = result.stdout.decode("utf-8")
+
+    check(f"{file_path} (formatted again)", expected_output, output)
+
+# run_repl_test feeds the lines of a .in file to the REPL and compares what it
+# prints with the .txt file next to it.
+def run_repl_test(file_path):
+    expected_output = read_file(file_path)
+
+    input_path = file_path.split(".")[0] + ".in"
+    with open(input_path, "rb") as file:
+        result = subprocess.run(["go", "run", ".", "repl"], stdin=file, stdout=subprocess.PIPE)
+    output = result.stdout.decode("utf-8")
+
+    check(input_path, expected_output, output)
+
 def main():
     for file in os.listdir("tests"):
         if file.endswith("txt"):
             run_test("tests/" + file)
 
+    for file in os.listdir("tests/fmt"):
+        if file.endswith("txt"):
+            run_fmt_test("tests/fmt/" + file)
+
+    for file in os.listdir("tests/repl"):
+        if file.endswith("txt"):
+            run_repl_test("tests/repl/" + file)
+
     print()
     print(f"Total tests run: {tests_run}. ({tests_passed}/{tests_run})")
 
 if __name__ == "__main__":
-    main()
\ No newline at end of file
+    main()
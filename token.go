@@ -1,5 +1,10 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+)
+
 type TokenType int
 
 const (
@@ -9,7 +14,10 @@ const (
 	HEX
 	FLOAT
 	STRING
+	ISTRING
+	CHAR
 	BOOL
+	NIL
 
 	OPERATOR
 
@@ -23,6 +31,8 @@ const (
 	RPAREN
 	LCURLY
 	RCURLY
+	LBRACKET
+	RBRACKET
 
 	SEMI
 	COMMA
@@ -37,6 +47,8 @@ var enumToString = []string{
 	"HEX",
 	"FLOAT",
 	"STRING",
+	"INTERPOLATED STRING",
+	"CHAR",
 	"BOOL",
 	"NIL",
 	"OPERATOR",
@@ -47,6 +59,8 @@ var enumToString = []string{
 	"RPAREN",
 	"LCURLY",
 	"RCURLY",
+	"LBRACKET",
+	"RBRACKET",
 	"SEMI COLON",
 	"COMMA",
 	"LINE COMMENT",
@@ -56,6 +70,44 @@ var enumToString = []string{
 type Token struct {
 	Type TokenType
 	Data string
+	Pos  Pos
+	// Raw is the token as it is written in the source.
+	Raw string
+	// Parts holds the text and embedded expressions of an ISTRING.
+	Parts []StrPart
+
+	// Leading holds the comments in front of the token and Trailing the ones
+	// after it on the same line, as LCOMMENT and BCOMMENT tokens.
+	Leading  []Token
+	Trailing []Token
+}
+
+// EndLine returns the line the token ends on, strings and comments can span
+// several lines.
+func (t Token) EndLine() int {
+	return t.Pos.Line + strings.Count(t.Raw, "\n")
+}
+
+// StrPart is either text or, when Tokens is not nil, the tokens of an
+// expression embedded in a string, ending in EOF.
+type StrPart struct {
+	Text   string
+	Tokens []Token
+}
+
+// Pos is a position in the source, File holds the loc of the program.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
 }
 
 func (t *Token) Name() string {
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type TypeKind int
+
+const (
+	NoType TypeKind = iota
+	NamedType
+	SliceType
+	MapType
+	TupleType
+	// DynType is a trait object, Name holds the trait.
+	DynType
+	// FuncType is a function, Elems holds the parameter types followed by the
+	// return type.
+	FuncType
+)
+
+// TypeSpec is a type as it is written in the source, e.g. `u8`, `&Vec2`,
+// `[]str`, `map[str]i32`, `(i32, str)`, `Pair<i32, str>`, `&dyn Printable`,
+// `fun(i32) -> bool` or `Node?`.
+type TypeSpec struct {
+	Kind  TypeKind
+	Name  string
+	IsRef bool
+	// IsNullable types also hold nil, e.g. `i32?`. On a reference type it is
+	// the reference that may be nil, `&Node?` is short for `(&Node)?`.
+	IsNullable bool
+	// IsNullableTarget is set on a reference to a value that may be nil,
+	// `&(i32?)`. The reference itself is never nil.
+	IsNullableTarget bool
+	// Elems holds the element type of a slice, the key and value types of a map,
+	// the element types of a tuple, the parameter and return types of a function
+	// or the type arguments of a generic type.
+	Elems []TypeSpec
+	// IsLiteral is set on the type of an integer literal without a suffix,
+	// which fits in any integer type.
+	IsLiteral bool
+	// Source is the type as it is written, with the aliases and `Self` it
+	// names. The formatter prints it.
+	Source string
+}
+
+func NamedTypeSpec(name string) TypeSpec {
+	return TypeSpec{
+		Kind: NamedType,
+		Name: name,
+	}
+}
+
+// FuncTypeSpec is the type of functions taking params and returning ret.
+// A missing return type is void.
+func FuncTypeSpec(params []TypeSpec, ret TypeSpec) TypeSpec {
+	if ret.Kind == NoType {
+		ret = NamedTypeSpec("void")
+	}
+
+	return TypeSpec{
+		Kind:  FuncType,
+		Elems: append(append([]TypeSpec{}, params...), ret),
+	}
+}
+
+// RefTo returns the type of a reference to a value of type t.
+func RefTo(t TypeSpec) TypeSpec {
+	t.IsNullableTarget = t.IsNullable
+	t.IsNullable = false
+	t.IsRef = true
+	return t
+}
+
+// Referent returns the type of the value a reference of type t refers to,
+// other types are returned as they are.
+func Referent(t TypeSpec) TypeSpec {
+	if !t.IsRef {
+		return t
+	}
+
+	t.IsNullable = t.IsNullableTarget
+	t.IsNullableTarget = false
+	t.IsRef = false
+	return t
+}
+
+// Params returns the parameter types of a function type.
+func (t TypeSpec) Params() []TypeSpec {
+	return t.Elems[:len(t.Elems)-1]
+}
+
+// Return returns the return type of a function type.
+func (t TypeSpec) Return() TypeSpec {
+	return t.Elems[len(t.Elems)-1]
+}
+
+func (t TypeSpec) String() string {
+	if t.IsNullable {
+		t.IsNullable = false
+		// `fun() -> i32?` would return a nullable
+		if t.Kind == FuncType {
+			return "(" + t.String() + ")?"
+		}
+		return t.String() + "?"
+	}
+
+	if t.IsNullableTarget {
+		t.IsRef, t.IsNullableTarget, t.IsNullable = false, false, true
+		return "&(" + t.String() + ")"
+	}
+
+	ref := ""
+	if t.IsRef {
+		ref = "&"
+	}
+
+	switch t.Kind {
+	case NamedType:
+		if len(t.Elems) > 0 {
+			return fmt.Sprintf("%s%s<%s>", ref, t.Name, joinTypeSpecs(t.Elems))
+		}
+		return ref + t.Name
+	case SliceType:
+		return fmt.Sprintf("%s[]%s", ref, t.Elems[0].String())
+	case MapType:
+		return fmt.Sprintf("%smap[%s]%s", ref, t.Elems[0].String(), t.Elems[1].String())
+	case TupleType:
+		return fmt.Sprintf("%s(%s)", ref, joinTypeSpecs(t.Elems))
+	case DynType:
+		return fmt.Sprintf("%sdyn %s", ref, t.Name)
+	case FuncType:
+		return fmt.Sprintf("%sfun(%s) -> %s", ref, joinTypeSpecs(t.Params()), t.Return())
+	}
+
+	return ""
+}
+
+func joinTypeSpecs(types []TypeSpec) string {
+	return strings.Join(Map(types, func(t TypeSpec) string {
+		return t.String()
+	}), ", ")
+}
@@ -7,28 +7,56 @@ type Visitor interface {
 	VisitLocStmt(LocStmt) AvaVal
 
 	VisitParenExpr(ParenExpr) AvaVal
+	VisitNamedArg(NamedArg) AvaVal
 
 	VisitBlock(Block) AvaVal
 
 	VisitIfStmt(IfStmt) AvaVal
 	VisitWhileStmt(WhileStmt) AvaVal
+	VisitForStmt(ForStmt) AvaVal
 
 	VisitStructDecl(StructDecl) AvaVal
+	VisitTypeDecl(TypeDecl) AvaVal
+	VisitEnumDecl(EnumDecl) AvaVal
+	VisitTraitDecl(TraitDecl) AvaVal
+	VisitImplDecl(ImplDecl) AvaVal
 
 	VisitAssignStmt(AssignStmt) AvaVal
 
 	VisitExprStmt(ExprStmt) AvaVal
+	VisitReturnStmt(ReturnStmt) AvaVal
+	VisitDeferStmt(DeferStmt) AvaVal
 
 	VisitFuncCall(FuncCall) AvaVal
+	VisitCallExpr(CallExpr) AvaVal
 
 	VisitFuncDecl(FuncDecl) AvaVal
 	VisitConstDecl(ConstDecl) AvaVal
 	VisitVarDecl(VarDecl) AvaVal
+	VisitTupleVarDecl(TupleVarDecl) AvaVal
 
 	VisitVariable(Variable) AvaVal
+	VisitIndexExpr(IndexExpr) AvaVal
+	VisitFieldExpr(FieldExpr) AvaVal
+	VisitMethodCall(MethodCall) AvaVal
+	VisitRefExpr(RefExpr) AvaVal
+	VisitDerefExpr(DerefExpr) AvaVal
+	VisitCoalesceExpr(CoalesceExpr) AvaVal
+	VisitTryExpr(TryExpr) AvaVal
+	VisitRecoverExpr(RecoverExpr) AvaVal
+	VisitMatchExpr(MatchExpr) AvaVal
+	VisitRangeExpr(RangeExpr) AvaVal
 
 	VisitIntLit(IntLit) AvaVal
 	VisitFloatLit(FloatLit) AvaVal
 	VisitBoolLit(BoolLit) AvaVal
 	VisitStrLit(StrLit) AvaVal
+	VisitInterpStr(InterpStr) AvaVal
+	VisitCharLit(CharLit) AvaVal
+	VisitNilLit(NilLit) AvaVal
+	VisitFuncLit(FuncLit) AvaVal
+	VisitArrayLit(ArrayLit) AvaVal
+	VisitMapLit(MapLit) AvaVal
+	VisitTupleLit(TupleLit) AvaVal
+	VisitStructLit(StructLit) AvaVal
 }